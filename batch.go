@@ -0,0 +1,146 @@
+package bloomtree
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// BatchProof is a single compact multiproof for the presence, or absence of many elements. The chunks and
+// proof hashes are shared by all elements, so hashes needed by several elements are only sent once.
+type BatchProof struct {
+	// Chunks are the raw bloom filter words of all leaves needed by the proof, ordered by chunk index.
+	Chunks [][]uint64
+	// Proof are the hashes needed to reconstruct the bloom tree root.
+	Proof [][32]byte
+	// ProofTypes holds the proof type of every element, in the order the elements were given.
+	// A proof type is 255 if the element is present, otherwise it is the index of the element index that
+	// is zero in the bloom filter.
+	ProofTypes []uint8
+	// ChunkSize is the number of bloom filter bits per chunk of the tree the proof was generated from.
+	ChunkSize int
+	// Hasher is the hash function of the tree the proof was generated from.
+	Hasher Hasher
+	// HashMode is the leaf and node encoding of the tree the proof was generated from.
+	HashMode HashMode
+	// Layout is the leaf arrangement of the tree the proof was generated from.
+	Layout Layout
+}
+
+// GenerateBatchProof returns a single compact multiproof for the presence, or absence of all given elements.
+func (bt *BloomTree) GenerateBatchProof(elems [][]byte) (*BatchProof, error) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.batchProof(bt, elems)
+}
+
+// batchProof returns the batch proof of the elements for the given state of the tree.
+func (bt *BloomTree) batchProof(v treeView, elems [][]byte) (*BatchProof, error) {
+	if len(elems) == 0 {
+		return nil, errors.New("at least 1 element is required for a batch proof")
+	}
+	params := bt.Params()
+	var indices []uint64
+	proofTypes := make([]uint8, len(elems))
+	for i, elem := range elems {
+		elemIndices, proofType := bt.elementProof(v, elem)
+		indices = append(indices, elemIndices...)
+		proofTypes[i] = proofType
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	chunks, chunkIndices := bt.getChunksAndIndices(v, indices)
+	proof, err := bt.generateProof(v, chunkIndices)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchProof{
+		Chunks:     chunks,
+		Proof:      proof,
+		ProofTypes: proofTypes,
+		ChunkSize:  params.ChunkSize,
+		Hasher:     params.Hasher,
+		HashMode:   params.HashMode,
+		Layout:     params.Layout,
+	}, nil
+}
+
+// VerifyBatchProof returns whether the batch proof for the given elements is true or false.
+// As with VerifyCompactMultiProof, the bloom filter is only used to map the elements to their indices, and the
+// options must be the ones the tree was created with.
+func VerifyBatchProof(elems [][]byte, seedValue []byte, batch *BatchProof, root [32]byte, bf BloomFilter, opts ...Option) (bool, error) {
+	if batch == nil {
+		return false, errors.New("there was no proof provided")
+	}
+	params, err := verifierParams(bf, opts)
+	if err != nil {
+		return false, err
+	}
+	if err := params.checkProof(batch.ChunkSize, batch.Hasher, batch.HashMode, batch.Layout); err != nil {
+		return false, err
+	}
+	elemIndices := make([][]uint, len(elems))
+	for i, elem := range elems {
+		elemIndices[i] = bf.MapElementToBF(elem, seedValue)
+	}
+	return verifyBatchProof(elemIndices, batch, root, params)
+}
+
+// VerifyBatch returns whether the batch proof for the given elements is true or false.
+func (v *Verifier) VerifyBatch(elems [][]byte, batch *BatchProof) (bool, error) {
+	if batch == nil {
+		return false, errors.New("there was no proof provided")
+	}
+	if err := v.params.checkProof(batch.ChunkSize, batch.Hasher, batch.HashMode, batch.Layout); err != nil {
+		return false, err
+	}
+	elemIndices := make([][]uint, len(elems))
+	for i, elem := range elems {
+		elemIndices[i] = mapElement(elem, v.seed, v.params)
+	}
+	return verifyBatchProof(elemIndices, batch, v.root, v.params)
+}
+
+func verifyBatchProof(elemIndices [][]uint, batch *BatchProof, root [32]byte, params Params) (bool, error) {
+	if err := params.validate(); err != nil {
+		return false, err
+	}
+	if len(elemIndices) == 0 {
+		return false, errors.New("at least 1 element is required for a batch proof")
+	}
+	if len(batch.ProofTypes) != len(elemIndices) {
+		return false, errors.New("the number of proof types does not match the number of elements")
+	}
+	// collect the indices every element relies on
+	proven := make([][]uint, len(elemIndices))
+	var all []uint
+	for i, indices := range elemIndices {
+		proofType := batch.ProofTypes[i]
+		if CheckProofType(proofType) {
+			proven[i] = indices
+		} else {
+			if int(proofType) >= len(indices) {
+				return false, fmt.Errorf("the proof type of element %d exceeds the number of element indices", i)
+			}
+			proven[i] = []uint{indices[proofType]}
+		}
+		all = append(all, proven[i]...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	unique := uniqueChunkIndices(computeChunkIndices(all, params.ChunkSize))
+	if len(unique) != len(batch.Chunks) {
+		return false, errors.New("the provided chunks do not match the element indices")
+	}
+	for i, indices := range proven {
+		present, err := checkChunkPresence(indices, unique, batch.Chunks, params.ChunkSize)
+		if err != nil {
+			return false, err
+		}
+		if CheckProofType(batch.ProofTypes[i]) && present != true {
+			return false, fmt.Errorf("element %d is not inside the provided chunks for a presence proof", i)
+		}
+		if !CheckProofType(batch.ProofTypes[i]) && present == true {
+			return false, fmt.Errorf("element %d cannot be inside the provided chunk for an absence proof", i)
+		}
+	}
+	return verifyChunks(unique, batch.Chunks, batch.Proof, root, params)
+}
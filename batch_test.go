@@ -0,0 +1,110 @@
+package bloomtree
+
+import (
+	"testing"
+)
+
+func TestBatchProof(t *testing.T) {
+	seed := "secret seed"
+	var elements [][]byte
+	for i := 0; i < 50; i++ {
+		elements = append(elements, []byte{byte(i)})
+	}
+	dbf := generateDBF(200, seed, elements...)
+	tree, err := NewBloomTree(dbf, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// present and absent elements mixed, including a duplicate
+	var batch [][]byte
+	for i := 0; i < 100; i += 3 {
+		batch = append(batch, []byte{byte(i)})
+	}
+	batch = append(batch, []byte{0})
+
+	proof, err := tree.GenerateBatchProof(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.ProofTypes) != len(batch) {
+		t.Fatalf("expected %d proof types, but got %d", len(batch), len(proof.ProofTypes))
+	}
+	hashes := 0
+	for i, elem := range batch {
+		single, err := tree.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if single.ProofType != proof.ProofTypes[i] {
+			t.Fatalf("expected proof type %d for element %v, but got %d", single.ProofType, elem, proof.ProofTypes[i])
+		}
+		hashes += len(single.Proof)
+	}
+	if len(proof.Proof) >= hashes {
+		t.Fatalf("expected the batch proof to have fewer than %d hashes, but got %d", hashes, len(proof.Proof))
+	}
+
+	verified, err := VerifyBatchProof(batch, []byte(seed), proof, tree.Root(), dbf, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified {
+		t.Fatal("failed to verify batch proof")
+	}
+	verifier, err := NewVerifier(tree.Root(), tree.Params(), []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err = verifier.VerifyBatch(batch, proof)
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified {
+		t.Fatal("failed to verify batch proof with the verifier")
+	}
+}
+
+func TestBatchProofRejected(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}}...)
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := [][]byte{{1}, {2}, {9}, {17}}
+	verifier, err := NewVerifier(tree.Root(), tree.Params(), []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name   string
+		elems  [][]byte
+		modify func(p *BatchProof)
+	}{
+		{name: "missing element", elems: batch[:3], modify: func(p *BatchProof) {}},
+		{name: "swapped elements", elems: [][]byte{{9}, {2}, {1}, {17}}, modify: func(p *BatchProof) {}},
+		{name: "absent claimed present", elems: batch, modify: func(p *BatchProof) { p.ProofTypes[2] = maxK }},
+		{name: "present claimed absent", elems: batch, modify: func(p *BatchProof) { p.ProofTypes[0] = 0 }},
+		{name: "tampered chunk", elems: batch, modify: func(p *BatchProof) { p.Chunks[0][0] |= p.Chunks[0][0] + 1 }},
+		{name: "missing hash", elems: batch, modify: func(p *BatchProof) { p.Proof = p.Proof[1:] }},
+		{name: "other hasher", elems: batch, modify: func(p *BatchProof) { p.Hasher = SHA256 }},
+		{name: "other chunk size", elems: batch, modify: func(p *BatchProof) { p.ChunkSize = 1 << 30 }},
+	}
+
+	for _, test := range tests {
+		proof, err := tree.GenerateBatchProof(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.modify(proof)
+		if verified, err := verifier.VerifyBatch(test.elems, proof); err == nil && verified {
+			t.Fatalf("expected batch proof with %s to be rejected", test.name)
+		}
+		if verified, err := VerifyBatchProof(test.elems, []byte(seed), proof, tree.Root(), dbf); err == nil && verified {
+			t.Fatalf("expected batch proof with %s to be rejected without a verifier", test.name)
+		}
+	}
+
+	if _, err := tree.GenerateBatchProof(nil); err == nil {
+		t.Fatal("expected error for an empty batch")
+	}
+}
@@ -0,0 +1,87 @@
+package bloomtree
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/willf/bitset"
+)
+
+// blockSizer is implemented by bloom filters that map every element into a single block of bits.
+// Trees built from such a bloom filter use the block size as chunk size.
+type blockSizer interface {
+	BlockSize() int
+}
+
+// BlockedFilter is a bloom filter that is split into blocks of equal size, where all k bits of an element fall
+// into one block. A tree built from the filter uses the block size as chunk size, so a presence proof covers a
+// single chunk and its Merkle path. The indices of an element are derived with keyed double hashing:
+//
+//	d     = SHA512/256(len(seed) as 8 bytes little endian || seed || element)
+//	block = the first 8 bytes of d, little endian, mod (m / blockSize)
+//	h1    = the next 8 bytes of d, little endian
+//	h2    = the 8 bytes after h1, little endian, with the lowest bit set
+//	index i = block*blockSize + (h1 + i*h2) mod 2^64 mod blockSize, for i = 0, ..., k-1
+type BlockedFilter struct {
+	keyedFilter
+}
+
+// NewBlockedFilter creates an empty blocked bloom filter of m bits and k hash functions, keyed with the seed.
+// The block size must be divisible by 64 and m must be a multiple of the block size.
+func NewBlockedFilter(m, k uint, blockSize int, seed []byte) (*BlockedFilter, error) {
+	if err := validChunkSize(blockSize); err != nil {
+		return nil, err
+	}
+	if m == 0 || m%uint(blockSize) != 0 {
+		return nil, fmt.Errorf("the number of bits must be a positive multiple of the block size %d", blockSize)
+	}
+	f, err := newKeyedFilter(m, k, seed, BlockedIndexScheme)
+	if err != nil {
+		return nil, err
+	}
+	f.blockSize = uint(blockSize)
+	return &BlockedFilter{f}, nil
+}
+
+// blockedHashing returns the indices of an element for the given seed using keyed double hashing within a block.
+func blockedHashing(element, seed []byte, m, k, blockSize uint) []uint {
+	data := make([]byte, 8, 8+len(seed)+len(element))
+	binary.LittleEndian.PutUint64(data, uint64(len(seed)))
+	data = append(data, seed...)
+	data = append(data, element...)
+	d := sha512.Sum512_256(data)
+	block := binary.LittleEndian.Uint64(d[0:8]) % uint64(m/blockSize)
+	h1 := binary.LittleEndian.Uint64(d[8:16])
+	h2 := binary.LittleEndian.Uint64(d[16:24]) | 1
+	indices := make([]uint, k)
+	for i := uint64(0); i < uint64(k); i++ {
+		indices[i] = uint(block*uint64(blockSize) + (h1+i*h2)%uint64(blockSize))
+	}
+	return indices
+}
+
+// SetBitSet replaces the bits of the bloom filter. It returns an error if the bit set does not have the length
+// of the bloom filter.
+func (f *BlockedFilter) SetBitSet(b *bitset.BitSet) error {
+	return f.setBitSet(b)
+}
+
+// BlockSize returns the number of bits per block.
+func (f *BlockedFilter) BlockSize() int {
+	return int(f.blockSize)
+}
+
+// alignChunkSize returns the chunk size of a tree for the bloom filter. Blocked bloom filters require the chunk
+// size to match their block size.
+func alignChunkSize(b BloomFilter, c config) (int, error) {
+	bs, ok := b.(blockSizer)
+	if !ok {
+		return c.chunkSize, nil
+	}
+	if c.chunkSizeSet && c.chunkSize != bs.BlockSize() {
+		return 0, errors.New("the chunk size must match the block size of the bloom filter")
+	}
+	return bs.BlockSize(), nil
+}
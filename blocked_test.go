@@ -0,0 +1,96 @@
+package bloomtree
+
+import (
+	"testing"
+)
+
+func TestBlockedFilterIndices(t *testing.T) {
+	f, err := NewBlockedFilter(4096, 7, 512, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		indices := f.GetElementIndices([]byte{byte(i)})
+		if len(indices) != 7 {
+			t.Fatalf("expected 7 indices, but got %d", len(indices))
+		}
+		for _, index := range indices {
+			if index/512 != indices[0]/512 {
+				t.Fatalf("expected all indices of element %d in one block, but got %v", i, indices)
+			}
+		}
+	}
+
+	var tests = []struct {
+		m         uint
+		k         uint
+		blockSize int
+	}{
+		{m: 4096, k: 7, blockSize: 100},
+		{m: 4000, k: 7, blockSize: 512},
+		{m: 0, k: 7, blockSize: 512},
+		{m: 4096, k: 0, blockSize: 512},
+	}
+	for _, test := range tests {
+		if _, err := NewBlockedFilter(test.m, test.k, test.blockSize, nil); err == nil {
+			t.Fatalf("expected error for m %d, k %d and block size %d", test.m, test.k, test.blockSize)
+		}
+	}
+}
+
+func TestBlockedFilterProofs(t *testing.T) {
+	seed := []byte("secret seed")
+	f, err := NewBlockedFilter(8192, 6, 256, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i += 2 {
+		f.Add([]byte{byte(i)})
+	}
+	tree, err := NewBloomTree(f, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Params().ChunkSize != 256 {
+		t.Fatalf("expected the chunk size to match the block size 256, but got %d", tree.Params().ChunkSize)
+	}
+	if tree.Params().IndexScheme != BlockedIndexScheme {
+		t.Fatalf("expected index scheme %v, but got %v", BlockedIndexScheme, tree.Params().IndexScheme)
+	}
+	verifier, err := NewVerifier(tree.Root(), tree.Params(), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height := 5 // 32 chunks
+	for i := 0; i < 200; i += 2 {
+		elem := []byte{byte(i)}
+		proof, err := tree.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !CheckProofType(proof.ProofType) {
+			t.Fatalf("expected presence proof for element %d", i)
+		}
+		if len(proof.Chunks) != 1 || len(proof.Proof) != height {
+			t.Fatalf("expected 1 chunk and %d hashes, but got %d chunks and %d hashes", height, len(proof.Chunks), len(proof.Proof))
+		}
+		verified, err := verifier.Verify(elem, proof)
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof of element %d", i)
+		}
+	}
+
+	if _, err := NewBloomTree(f, WithChunkSize(64)); err == nil {
+		t.Fatal("expected error for a chunk size other than the block size")
+	}
+	if _, err := NewBloomTree(f, WithChunkSize(256)); err != nil {
+		t.Fatal(err)
+	}
+	params := tree.Params()
+	params.ChunkSize = 192
+	if _, err := NewVerifier(tree.Root(), params, seed); err == nil {
+		t.Fatal("expected error for a bloom filter that is not a multiple of the chunk size")
+	}
+}
@@ -1,11 +1,12 @@
 package bloomtree
 
 import (
-	"crypto/sha512"
 	"errors"
 	"fmt"
 	"math"
 	"sort"
+	"sync"
+	"sync/atomic"
 
 	"github.com/willf/bitset"
 )
@@ -18,6 +19,16 @@ import (
 // index, false (where "index" is one of the element indices that have a zero value in the bloom filter).
 const maxK = uint8(255)
 
+// defaultChunkSize is the chunk size of trees created without the WithChunkSize option.
+const defaultChunkSize = 64
+
+// presetChunkSize replaces defaultChunkSize for trees created by NewBloomTree without the WithChunkSize option
+// after a call of SetChunkSize. It is accessed atomically.
+var presetChunkSize = int32(defaultChunkSize)
+
+// maxChunkSize is the largest chunk size in bits. It bounds the memory used to hash a leaf of a decoded proof.
+const maxChunkSize = 1 << 16
+
 type BloomFilter interface {
 	Proof([]byte) ([]uint64, bool)
 	BitArray() *bitset.BitSet
@@ -26,14 +37,222 @@ type BloomFilter interface {
 	GetElementIndices([]byte) []uint
 }
 
+// Params describes the geometry of a bloom tree. Together with the root and the seed of the bloom filter,
+// it is all a verifier needs to check compact multiproofs.
+type Params struct {
+	// M is the length of the bloom filter in bits.
+	M uint
+	// K is the number of hash functions of the bloom filter.
+	K uint
+	// ChunkSize is the number of bloom filter bits stored in a leaf.
+	ChunkSize int
+	// Hasher is the hash function of the leaves and internal nodes.
+	Hasher Hasher
+	// HashMode is the encoding of the leaves and internal nodes before hashing.
+	HashMode HashMode
+	// IndexScheme is how the bloom filter maps elements to indices.
+	IndexScheme IndexScheme
+	// Layout is the arrangement of the leaves of the tree.
+	Layout Layout
+}
+
+func (p Params) validate() error {
+	if p.M == 0 {
+		return errors.New("the bloom filter must have at least 1 bit")
+	}
+	if err := validK(p.K); err != nil {
+		return err
+	}
+	if err := validChunkSize(p.ChunkSize); err != nil {
+		return err
+	}
+	if !p.Hasher.Valid() {
+		return fmt.Errorf("unknown hasher %v", p.Hasher)
+	}
+	if !p.HashMode.Valid() {
+		return fmt.Errorf("unknown hash mode %v", p.HashMode)
+	}
+	if !p.IndexScheme.Valid() {
+		return fmt.Errorf("unknown index scheme %v", p.IndexScheme)
+	}
+	if !p.Layout.Valid() {
+		return fmt.Errorf("unknown layout %v", p.Layout)
+	}
+	if p.IndexScheme == BlockedIndexScheme && p.M%uint(p.ChunkSize) != 0 {
+		return errors.New("the bloom filter of a blocked index scheme must be a multiple of the chunk size")
+	}
+	return nil
+}
+
+// numWords returns the number of 64 bit words of a bloom filter of m bits.
+func numWords(m uint) int {
+	return int((m + 63) / 64)
+}
+
+// numLeafs returns the number of chunks of a bloom filter of numWords words.
+func numLeafs(numWords, chunkSize int) int {
+	step := chunkSize / 64
+	return (numWords + step - 1) / step
+}
+
 // BloomTree represents the bloom tree struct.
+//
+// A BloomTree is safe for concurrent use. Proofs and roots may be requested from many goroutines while another
+// goroutine updates the tree with Add, Remove or Refresh. Updates are applied atomically: every proof is generated
+// from a single consistent state of the words and nodes of the tree, and matches the root of that state. The bloom
+// filter itself is not synchronized, so it must only be changed through the tree, or while no proofs are generated
+// from it.
 type BloomTree struct {
-	bf    BloomFilter
-	nodes [][32]byte
+	mu sync.RWMutex
+	bf BloomFilter
+	// words is a copy of the bloom filter words the nodes were computed from. Proofs read chunks and element
+	// bits from it, so they always match the nodes, even after bits were added to the bloom filter but not yet
+	// refreshed. Snapshots keep the old value of a word before a refresh overwrites it, which is impossible once
+	// the bloom filter itself changed.
+	// The copy costs m/8 bytes, an eighth of the nodes of a tree with the default chunk size, and copying it
+	// takes well under 1% of a build (BenchmarkNewBloomTreeWords: 1.5ms for 2 MiB next to 16 MiB of nodes built
+	// in 350ms).
+	words []uint64
+	m     uint
+	k     uint
+	// store holds the nodes, root is a copy of the top node.
+	store     NodeStore
+	root      Root
+	snapshots map[*Snapshot]struct{}
+	chunkSize int
+	hasher    Hasher
+	mode      HashMode
+	layout    Layout
+	// shape is the shape of the tree, it never changes after the tree is built.
+	shape treeShape
+}
+
+type config struct {
+	chunkSize    int
+	chunkSizeSet bool
+	hasher       Hasher
+	mode         HashMode
+	layout       Layout
+	workers      int
+	store        NodeStore
+}
+
+// Option configures a bloom tree created by NewBloomTree.
+type Option func(*config)
+
+// WithChunkSize sets the number of bloom filter bits stored in a leaf. The value must be divisible by 64,
+// the default is 64, or the block size of a blocked bloom filter.
+func WithChunkSize(v int) Option {
+	return func(c *config) {
+		c.chunkSize = v
+		c.chunkSizeSet = true
+	}
+}
+
+// SetChunkSize sets the chunk size of the trees created afterwards by NewBloomTree without the WithChunkSize
+// option. It does not change the chunk size VerifyCompactMultiProof and VerifyBatchProof expect.
+//
+// Deprecated: the chunk size is a per-tree option now, use WithChunkSize.
+func SetChunkSize(v int) error {
+	if err := validChunkSize(v); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&presetChunkSize, int32(v))
+	return nil
+}
+
+// WithHasher sets the hash function of the leaves and internal nodes. The default is SHA512_256.
+func WithHasher(h Hasher) Option {
+	return func(c *config) {
+		c.hasher = h
+	}
+}
+
+// WithHashMode sets the encoding of the leaves and internal nodes before hashing. The default is
+// LegacyHashMode, new trees should use HardenedHashMode.
+func WithHashMode(m HashMode) Option {
+	return func(c *config) {
+		c.mode = m
+	}
+}
+
+// WithLayout sets the arrangement of the leaves. The default is PaddedLayout, BalancedLayout avoids padding
+// leaves for bloom filters whose number of chunks is not a power of two.
+func WithLayout(l Layout) Option {
+	return func(c *config) {
+		c.layout = l
+	}
+}
+
+// WithWorkers sets the number of goroutines that hash the leaves and every level of the tree when it is built.
+// The default is 1. The root does not depend on the number of workers.
+func WithWorkers(n int) Option {
+	return func(c *config) {
+		c.workers = n
+	}
+}
+
+// WithNodeStore sets the store that holds the nodes of the tree. The default keeps all nodes in memory, an
+// MmapStore keeps the bits of the bloom filter, the tree's copy of them and the nodes in a memory-mapped file, and a BoltStore persists the tree
+// in a database. A store holds a single tree.
+func WithNodeStore(s NodeStore) Option {
+	return func(c *config) {
+		c.store = s
+	}
+}
+
+func validChunkSize(v int) error {
+	if v <= 0 || v%64 != 0 {
+		return errors.New("The chunk size must be divisible by 64")
+	}
+	if v > maxChunkSize {
+		return fmt.Errorf("The chunk size must not exceed %d", maxChunkSize)
+	}
+	return nil
+}
+
+// newConfig applies the options to the defaults, with the given default chunk size, and validates the result for
+// the bloom filter.
+func newConfig(b BloomFilter, chunkSize int, opts []Option) (config, error) {
+	c := config{chunkSize: chunkSize, hasher: SHA512_256, mode: LegacyHashMode, workers: 1}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	chunkSize, err := alignChunkSize(b, c)
+	if err != nil {
+		return config{}, err
+	}
+	c.chunkSize = chunkSize
+	if err := validChunkSize(c.chunkSize); err != nil {
+		return config{}, err
+	}
+	if scheme := indexSchemeOf(b); !scheme.Valid() {
+		return config{}, fmt.Errorf("unknown index scheme %v", scheme)
+	}
+	if !c.hasher.Valid() {
+		return config{}, fmt.Errorf("unknown hasher %v", c.hasher)
+	}
+	if !c.mode.Valid() {
+		return config{}, fmt.Errorf("unknown hash mode %v", c.mode)
+	}
+	if !c.layout.Valid() {
+		return config{}, fmt.Errorf("unknown layout %v", c.layout)
+	}
+	if c.workers < 1 {
+		return config{}, errors.New("the number of workers must be at least 1")
+	}
+	return c, nil
 }
 
 // NewBloomTree creates a new bloom tree.
-func NewBloomTree(b BloomFilter) (*BloomTree, error) {
+func NewBloomTree(b BloomFilter, opts ...Option) (*BloomTree, error) {
+	c, err := newConfig(b, int(atomic.LoadInt32(&presetChunkSize)), opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.store == nil {
+		c.store = newMemoryStore()
+	}
 	if b.NumOfHashes() >= uint(maxK) {
 		return nil, fmt.Errorf("parameter k of the bloom filter must be smaller than %d", maxK)
 	}
@@ -42,140 +261,191 @@ func NewBloomTree(b BloomFilter) (*BloomTree, error) {
 	if len(bfAsInt) == 0 {
 		return nil, errors.New("tree must have at least 1 leaf")
 	}
-	leafs := make([][sha512.Size256]byte, int(math.Ceil(float64(len(bfAsInt))/float64(chunkSize/64))))
-	hashLeafs(bfAsInt, leafs)
-	leafNum := int(math.Exp2(math.Ceil(math.Log2(float64(len(leafs))))))
-	nodes := make([][32]byte, (leafNum*2)-1)
-	for i, v := range leafs {
-		nodes[i] = v
+	bt := &BloomTree{
+		bf:        b,
+		m:         bf.Len(),
+		k:         b.NumOfHashes(),
+		chunkSize: c.chunkSize,
+		hasher:    c.hasher,
+		mode:      c.mode,
+		layout:    c.layout,
+		store:     c.store,
 	}
-	for i := len(leafs); i < leafNum; i++ {
-		nodes[i] = hashLeaf(uint64(0), uint64(i))
+	th := bt.treeHasher()
+	leafCount := numLeafs(len(bfAsInt), bt.chunkSize)
+	shape := newTreeShape(leafCount, bt.layout)
+	bt.shape = shape
+	words, nodes, allocated, err := allocateTree(c.store, shape, len(bfAsInt))
+	if err != nil {
+		return nil, err
 	}
-	for i := leafNum; i < len(nodes); i++ {
-		nodes[i] = hashChild(nodes[2*(i-leafNum)], nodes[2*(i-leafNum)+1])
+	copy(words, bfAsInt)
+	bt.words = words
+	if h, ok := c.store.(bitsHolder); ok {
+		if err := h.holdBits(b); err != nil {
+			return nil, err
+		}
 	}
-	return &BloomTree{
-		bf:    b,
-		nodes: nodes,
-	}, nil
+	buildNodes(th, shape, bt.words, leafCount, c.workers, nodes)
+	if p, ok := c.store.(persistentStore); ok {
+		if err := p.putTree(bt.Params(), seedReference(b), bt.words, shape, nodes); err != nil {
+			return nil, err
+		}
+	} else if !allocated {
+		if err := putNodes(c.store, shape, nodes); err != nil {
+			return nil, err
+		}
+	}
+	if err := flushStore(c.store); err != nil {
+		return nil, err
+	}
+	bt.root = nodes[len(nodes)-1]
+	return bt, nil
+}
+
+// parentHash returns the hash of the parent of the node at the given even position. If the node has no sibling,
+// it is moved up unchanged.
+func parentHash(th treeHasher, shape treeShape, nodes [][32]byte, level int, pos uint64) [32]byte {
+	left := nodes[shape.index(level, pos)]
+	if pos+1 >= uint64(shape.sizes[level]) {
+		return left
+	}
+	return th.child(left, nodes[shape.index(level, pos+1)])
 }
 
 func (bt *BloomTree) GetBloomFilter() BloomFilter {
 	return bt.bf
 }
 
-func order(a, b uint64) (uint64, uint64) {
-	if a > b {
-		return b, a
-	}
-	return a, b
+// treeView reads the words and nodes of one state of a bloom tree. The tree itself is a view of its current
+// state, snapshots are views of earlier states.
+type treeView interface {
+	word(i int) uint64
+	node(level int, pos uint64) ([32]byte, error)
+}
+
+func (bt *BloomTree) word(i int) uint64 {
+	return bt.words[i]
+}
 
+func (bt *BloomTree) node(level int, pos uint64) ([32]byte, error) {
+	return bt.store.Get(level, pos)
 }
 
-func (bt *BloomTree) generateProof(indices []uint64) ([][32]byte, error) {
+// generateProof returns the hashes needed to reconstruct the root from the leaves at the given sorted chunk
+// indices. The hashes are ordered by level, from the leaves to the root, and by position within a level.
+func (bt *BloomTree) generateProof(v treeView, indices []uint64) ([][32]byte, error) {
 	var hashes [][32]byte
-	var hashIndices []uint64
-	var hashIndicesBucket []int
-	var newIndices []uint64
-	prevIndices := indices
-	indMap := make(map[[2]uint64][2]int)
-	leavesPerLayer := uint64(len(bt.nodes) + 1)
-	currentLayer := uint64(0)
-	height := int(math.Log2(float64(len(bt.nodes) / 2)))
-	for i := 0; i <= height; i++ {
-		if len(newIndices) != 0 {
-			for j := 0; j < len(newIndices); j += 2 {
-				prevIndices = append(prevIndices, newIndices[j]/2)
+	shape := bt.shape
+	known := uniqueChunkIndices(indices)
+	for level := 0; level < shape.height(); level++ {
+		size := uint64(shape.sizes[level])
+		var parents []uint64
+		for i := 0; i < len(known); i++ {
+			pos := known[i]
+			if pos >= size {
+				return nil, errors.New("the chunk index exceeds the tree")
 			}
-			newIndices = nil
-		}
-		for _, val := range prevIndices {
-			neighbor := val ^ 1
-			a, b := order(val, neighbor)
-			pair := [2]uint64{a, b}
-			if _, ok := indMap[pair]; ok {
-				if indMap[pair][0] != int(val) {
-					indMap[pair] = [2]int{-1, 0}
+			sibling := pos ^ 1
+			if i+1 < len(known) && known[i+1] == sibling {
+				i++
+			} else if sibling < size {
+				h, err := v.node(level, sibling)
+				if err != nil {
+					return nil, err
 				}
-			} else {
-				indMap[pair] = [2]int{int(val), int(neighbor + currentLayer)}
-			}
-		}
-		for k, v := range indMap {
-			if v[0] != -1 {
-				hashIndicesBucket = append(hashIndicesBucket, v[1])
+				hashes = append(hashes, h)
 			}
-			newIndices = append(newIndices, k[0], k[1])
-		}
-		sort.Ints(hashIndicesBucket)
-		for _, elem := range hashIndicesBucket {
-			hashIndices = append(hashIndices, uint64(elem))
+			parents = append(parents, pos/2)
 		}
-		indMap = make(map[[2]uint64][2]int)
-		hashIndicesBucket = nil
-		leavesPerLayer /= 2
-		currentLayer += leavesPerLayer
-		prevIndices = nil
-	}
-	for _, hashInd := range hashIndices {
-		hashes = append(hashes, bt.nodes[hashInd])
+		known = parents
 	}
 	return hashes, nil
 }
 
-func (bt *BloomTree) getChunksAndIndices(indices []uint64) ([][32]byte, []uint64) {
-	chunks := make([][32]byte, len(indices))
+func (bt *BloomTree) getChunksAndIndices(v treeView, indices []uint64) ([][]uint64, []uint64) {
+	var chunks [][]uint64
 	chunkIndices := make([]uint64, len(indices))
-	bf := bt.bf.BitArray()
-	bfAsInt := bf.Bytes()
-	leafs := make([][sha512.Size256]byte, int(math.Ceil(float64(len(bfAsInt))/float64(chunkSize/64))))
-	hashLeafs(bfAsInt, leafs)
-	for i, v := range indices {
-		index := uint64(math.Floor(float64(v) / float64(chunkSize)))
-		chunks[i] = leafs[index]
+	for i, bit := range indices {
+		index := uint64(math.Floor(float64(bit) / float64(bt.chunkSize)))
 		chunkIndices[i] = index
+		if i > 0 && chunkIndices[i-1] == index {
+			continue
+		}
+		chunks = append(chunks, chunkWords(v, len(bt.words), index, bt.chunkSize))
 	}
 	return chunks, chunkIndices
 }
 
+// chunkWords returns a copy of the bloom filter words that make up the chunk at the given index, for a bloom
+// filter of numWords words.
+func chunkWords(v treeView, numWords int, index uint64, chunkSize int) []uint64 {
+	step := uint64(chunkSize / 64)
+	start := index * step
+	end := start + step
+	if end > uint64(numWords) {
+		end = uint64(numWords)
+	}
+	words := make([]uint64, end-start)
+	for i := range words {
+		words[i] = v.word(int(start) + i)
+	}
+	return words
+}
+
 // GenerateCompactMultiProof returns a compact multiproof to verify the presence, or absence of an element in a bloom tree.
 func (bt *BloomTree) GenerateCompactMultiProof(elem []byte) (*CompactMultiProof, error) {
-	var proofType uint8
-	indices, present := bt.bf.Proof(elem)
-	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
-	chunks, chunkIndices := bt.getChunksAndIndices(indices)
-	proof, err := bt.generateProof(chunkIndices)
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.compactMultiProof(bt, elem)
+}
+
+// compactMultiProof returns the compact multiproof of an element for the given state of the tree.
+func (bt *BloomTree) compactMultiProof(v treeView, elem []byte) (*CompactMultiProof, error) {
+	indices, proofType := bt.elementProof(v, elem)
+	chunks, chunkIndices := bt.getChunksAndIndices(v, indices)
+	proof, err := bt.generateProof(v, chunkIndices)
 	if err != nil {
-		return newCompactMultiProof(nil, nil, maxK), err
-	}
-	if present {
-		return newCompactMultiProof(chunks, proof, maxK), nil
+		return newCompactMultiProof(nil, nil, maxK, bt.Params()), err
 	}
+	return newCompactMultiProof(chunks, proof, proofType, bt.Params()), nil
+}
+
+// elementProof returns the sorted bloom filter indices that prove the presence, or absence of an element,
+// together with the proof type. The bits are read from the given state of the tree.
+func (bt *BloomTree) elementProof(v treeView, elem []byte) ([]uint64, uint8) {
 	allIndices := bt.bf.GetElementIndices(elem)
-	for i, v := range allIndices {
-		if indices[0] == uint64(v) {
-			proofType = uint8(i)
+	indices := make([]uint64, len(allIndices))
+	for i, bit := range allIndices {
+		if v.word(int(bit/64))&(1<<(bit%64)) == 0 {
+			return []uint64{uint64(bit)}, uint8(i)
 		}
+		indices[i] = uint64(bit)
 	}
-	return newCompactMultiProof(chunks, proof, proofType), nil
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices, maxK
 }
 
-// Root returns the Bloom Tree root
-func (bt *BloomTree) Root() [32]byte {
-	return bt.nodes[len(bt.nodes)-1]
+// Params returns the geometry of the bloom tree.
+func (bt *BloomTree) Params() Params {
+	return Params{
+		M:           bt.m,
+		K:           bt.k,
+		ChunkSize:   bt.chunkSize,
+		Hasher:      bt.hasher,
+		HashMode:    bt.mode,
+		IndexScheme: indexSchemeOf(bt.bf),
+		Layout:      bt.layout,
+	}
 }
 
-func hashLeafs(leaf []uint64, hashes [][sha512.Size256]byte) {
-	step := uint64(chunkSize / 64)
-	index := uint64(0)
-	length := uint64(len(leaf))
-	for i := uint64(0); i < length; i += step {
-		diff := step
-		if length-i < step {
-			diff = length - i
-		}
-		hashes[index] = hashLeaf(index, leaf[i:i+diff]...)
-		index = index + 1
-	}
+func (bt *BloomTree) treeHasher() treeHasher {
+	return newTreeHasher(bt.Params())
+}
+
+// Root returns the Bloom Tree root
+func (bt *BloomTree) Root() Root {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.root
 }
@@ -1,6 +1,7 @@
 package bloomtree
 
 import (
+	"encoding/hex"
 	"fmt"
 	"testing"
 
@@ -8,8 +9,6 @@ import (
 )
 
 func TestNewBloomTree64(t *testing.T) {
-	// set chunkSize to 64
-	SetChunkSize(64)
 	var tests = []struct {
 		elements [][]byte
 		hashAt   [3]int
@@ -31,19 +30,19 @@ func TestNewBloomTree64(t *testing.T) {
 
 	for _, test := range tests {
 		dbf := generateDBF(200, "secret seed", test.elements...)
-		tree, err := NewBloomTree(dbf)
+		// set chunkSize to 64
+		tree, err := NewBloomTree(dbf, WithChunkSize(64))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if hashChild(tree.nodes[test.hashAt[0]], tree.nodes[test.hashAt[1]]) != tree.nodes[test.hashAt[2]] {
+		nodes := nodesOf(tree)
+		if hashChild(SHA512_256, nodes[test.hashAt[0]], nodes[test.hashAt[1]]) != nodes[test.hashAt[2]] {
 			t.Fatalf("h(%d, %d) != %d", test.hashAt[0], test.hashAt[1], test.hashAt[2])
 		}
 	}
 }
 
 func TestNewBloomTree512(t *testing.T) {
-	// set chunkSize to 512
-	SetChunkSize(512)
 	var tests = []struct {
 		elements [][]byte
 		hashAt   [3]int
@@ -65,11 +64,13 @@ func TestNewBloomTree512(t *testing.T) {
 
 	for _, test := range tests {
 		dbf := generateDBF(2000, "secret seed", test.elements...)
-		tree, err := NewBloomTree(dbf)
+		// set chunkSize to 512
+		tree, err := NewBloomTree(dbf, WithChunkSize(512))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if hashChild(tree.nodes[test.hashAt[0]], tree.nodes[test.hashAt[1]]) != tree.nodes[test.hashAt[2]] {
+		nodes := nodesOf(tree)
+		if hashChild(SHA512_256, nodes[test.hashAt[0]], nodes[test.hashAt[1]]) != nodes[test.hashAt[2]] {
 			t.Fatalf("h(%d, %d) != %d", test.hashAt[0], test.hashAt[1], test.hashAt[2])
 		}
 	}
@@ -105,10 +106,9 @@ func TestGetBloomFilter(t *testing.T) {
 }
 
 func TestBloomTreeExceedingK64(t *testing.T) {
-	// set chunkSize to 64
-	SetChunkSize(64)
 	dbf := DBF.NewDbf(200, 1e-100, []byte("secret seed"))
-	_, err := NewBloomTree(dbf)
+	// set chunkSize to 64
+	_, err := NewBloomTree(dbf, WithChunkSize(64))
 	if err == nil {
 		t.Fatalf("expected error %v", fmt.Errorf("parameter k of the bloom filter must be smaller than %d", maxK))
 	} else if err.Error() != fmt.Errorf("parameter k of the bloom filter must be smaller than %d", maxK).Error() {
@@ -116,6 +116,217 @@ func TestBloomTreeExceedingK64(t *testing.T) {
 	}
 }
 
+func TestBloomTreeInvalidChunkSize(t *testing.T) {
+	dbf := generateDBF(200, "secret seed", []byte{1})
+	for _, chunkSize := range []int{0, -64, 100} {
+		_, err := NewBloomTree(dbf, WithChunkSize(chunkSize))
+		if err == nil {
+			t.Fatalf("expected error for chunk size %d", chunkSize)
+		}
+	}
+}
+
+func TestBloomTreesWithDifferentChunkSizes(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(2000, seed, [][]byte{{1}, {2}, {3}, {4}}...)
+	tree64, err := NewBloomTree(dbf, WithChunkSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree512, err := NewBloomTree(dbf, WithChunkSize(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree64.Root() == tree512.Root() {
+		t.Fatal("expected trees with different chunk sizes to have different roots")
+	}
+	for _, tree := range []*BloomTree{tree64, tree512, tree64} {
+		multiproof, err := tree.GenerateCompactMultiProof([]byte{1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if multiproof.ChunkSize != tree.Params().ChunkSize {
+			t.Fatalf("expected chunk size %d in proof, but got %d", tree.Params().ChunkSize, multiproof.ChunkSize)
+		}
+		verified, err := VerifyCompactMultiProof([]byte{1}, []byte(seed), multiproof, tree.Root(), dbf, WithChunkSize(tree.Params().ChunkSize))
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof with chunk size %d", multiproof.ChunkSize)
+		}
+		if tree == tree512 {
+			if _, err := VerifyCompactMultiProof([]byte{1}, []byte(seed), multiproof, tree.Root(), dbf); err == nil {
+				t.Fatal("expected error for a proof with a different chunk size than the tree")
+			}
+		}
+	}
+}
+
+func TestSetChunkSize(t *testing.T) {
+	defer SetChunkSize(defaultChunkSize)
+	if err := SetChunkSize(100); err == nil {
+		t.Fatal("expected error for a chunk size not divisible by 64")
+	}
+	if err := SetChunkSize(256); err != nil {
+		t.Fatal(err)
+	}
+	seed := "secret seed"
+	dbf := generateDBF(2000, seed, [][]byte{{1}, {2}}...)
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Params().ChunkSize != 256 {
+		t.Fatalf("expected chunk size 256, but got %d", tree.Params().ChunkSize)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof([]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyCompactMultiProof([]byte{1}, []byte(seed), multiproof, tree.Root(), dbf); err == nil {
+		t.Fatal("expected verification to keep the default chunk size")
+	}
+	if verified, err := VerifyCompactMultiProof([]byte{1}, []byte(seed), multiproof, tree.Root(), dbf, WithChunkSize(256)); err != nil || !verified {
+		t.Fatalf("failed to verify proof with the preset chunk size: %v", err)
+	}
+
+	// trees with an explicit chunk size may be created while the preset changes
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetChunkSize(128)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := NewBloomTree(dbf, WithChunkSize(64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestBloomTreeHashers(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}}...)
+	roots := make(map[[32]byte]Hasher)
+	for h := SHA512_256; h <= BLAKE3; h++ {
+		tree, err := NewBloomTree(dbf, WithHasher(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := roots[tree.Root()]; ok {
+			t.Fatalf("trees hashed with %v and %v have the same root", h, other)
+		}
+		roots[tree.Root()] = h
+		for _, elem := range [][]byte{{1}, {9}} {
+			multiproof, err := tree.GenerateCompactMultiProof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if multiproof.Hasher != h {
+				t.Fatalf("expected hasher %v in proof, but got %v", h, multiproof.Hasher)
+			}
+			verified, err := VerifyCompactMultiProof(elem, []byte(seed), multiproof, tree.Root(), dbf, WithHasher(h))
+			if err != nil {
+				t.Fatal(err)
+			} else if !verified {
+				t.Fatalf("failed to verify proof hashed with %v", h)
+			}
+		}
+	}
+	if _, err := NewBloomTree(dbf, WithHasher(Hasher(200))); err == nil {
+		t.Fatal("expected error for unknown hasher")
+	}
+}
+
+func TestLegacyRoot(t *testing.T) {
+	// roots of trees built before hashers and hash modes were configurable
+	var tests = []struct {
+		chunkSize int
+		root      string
+	}{
+		{
+			chunkSize: 64,
+			root:      "40aee9353bd60cf39eb173deab372f574e0b128563c653b74ee33504428a60bd",
+		},
+		{
+			chunkSize: 512,
+			root:      "df3bdd6d5715d4f62a904ab64f3d778768635e565288825809a60e6175e2c0ed",
+		},
+	}
+
+	for _, test := range tests {
+		// the bits of elements 1, 2 and 3 on 64-bit platforms, set directly because DBF derives different
+		// indices on 32-bit platforms
+		dbf := generateDBF(200, "secret seed")
+		for _, i := range []uint{122, 134, 173, 214, 228, 381, 395, 397, 512} {
+			dbf.BitArray().Set(i)
+		}
+		tree, err := NewBloomTree(dbf, WithChunkSize(test.chunkSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := tree.Root()
+		if hex.EncodeToString(root[:]) != test.root {
+			t.Fatalf("expected root %s for chunk size %d, but got %x", test.root, test.chunkSize, root)
+		}
+	}
+}
+
+func TestBloomTreeHardenedHashMode(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}}...)
+	legacy, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hardened, err := NewBloomTree(dbf, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy.Root() == hardened.Root() {
+		t.Fatal("expected legacy and hardened trees to have different roots")
+	}
+	th := hardened.treeHasher()
+	nodes := nodesOf(hardened)
+	leafNum := (len(nodes) + 1) / 2
+	for i := leafNum; i < len(nodes); i++ {
+		if th.child(nodes[2*(i-leafNum)], nodes[2*(i-leafNum)+1]) != nodes[i] {
+			t.Fatalf("node %d is not the hardened hash of its children", i)
+		}
+	}
+
+	for _, elem := range [][]byte{{1}, {9}} {
+		multiproof, err := hardened.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if multiproof.HashMode != HardenedHashMode {
+			t.Fatalf("expected hash mode %v in proof, but got %v", HardenedHashMode, multiproof.HashMode)
+		}
+		verified, err := VerifyCompactMultiProof(elem, []byte(seed), multiproof, hardened.Root(), dbf, WithHashMode(HardenedHashMode))
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatal("failed to verify hardened proof")
+		}
+		// the hash mode is taken from the caller, not from the proof
+		if _, err := VerifyCompactMultiProof(elem, []byte(seed), multiproof, hardened.Root(), dbf); err == nil {
+			t.Fatal("expected hardened proof to be rejected for a legacy tree")
+		}
+		// a legacy proof must not verify against the hardened root
+		multiproof.HashMode = LegacyHashMode
+		verified, err = VerifyCompactMultiProof(elem, []byte(seed), multiproof, hardened.Root(), dbf)
+		if err == nil && verified {
+			t.Fatal("expected legacy proof to be rejected against a hardened root")
+		}
+	}
+	if _, err := NewBloomTree(dbf, WithHashMode(HashMode(7))); err == nil {
+		t.Fatal("expected error for unknown hash mode")
+	}
+}
+
 func generateDBF(numElem uint, seed string, elements ...[]byte) *DBF.DistBF {
 	dbf := DBF.NewDbf(numElem, 0.2, []byte(seed))
 	for _, elem := range elements {
@@ -123,3 +334,78 @@ func generateDBF(numElem uint, seed string, elements ...[]byte) *DBF.DistBF {
 	}
 	return dbf
 }
+
+func TestProofsMatchTreeState(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(200, seed, []byte{1})
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+	indices := dbf.GetElementIndices([]byte{2})
+	changed := make([]int, len(indices))
+	for i, v := range indices {
+		changed[i] = int(v)
+	}
+	// the bloom filter changes, but the tree is not refreshed yet
+	dbf.SetIndices(changed)
+	proof, err := tree.GenerateCompactMultiProof([]byte{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckProofType(proof.ProofType) {
+		t.Fatal("expected absence proof before the tree is refreshed")
+	}
+	verified, err := VerifyCompactMultiProof([]byte{2}, []byte(seed), proof, root, dbf)
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified {
+		t.Fatal("failed to verify absence proof against the tree root")
+	}
+
+	if err := tree.Refresh(indices); err != nil {
+		t.Fatal(err)
+	}
+	proof, err = tree.GenerateCompactMultiProof([]byte{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckProofType(proof.ProofType) {
+		t.Fatal("expected presence proof after the tree is refreshed")
+	}
+	verified, err = VerifyCompactMultiProof([]byte{2}, []byte(seed), proof, tree.Root(), dbf)
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified {
+		t.Fatal("failed to verify presence proof against the refreshed root")
+	}
+}
+
+func benchmarkGenerateCompactMultiProof(b *testing.B, m uint) {
+	f, err := NewStandardFilter(m, 7, []byte("secret seed"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		f.Add([]byte{byte(i), byte(i >> 8)})
+	}
+	tree, err := NewBloomTree(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.GenerateCompactMultiProof([]byte{byte(i), byte(i >> 8)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerateCompactMultiProofSmall(b *testing.B) {
+	benchmarkGenerateCompactMultiProof(b, 1<<16)
+}
+
+func BenchmarkGenerateCompactMultiProofLarge(b *testing.B) {
+	benchmarkGenerateCompactMultiProof(b, 1<<24)
+}
@@ -0,0 +1,234 @@
+package bloomtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltNodesBucket = []byte("nodes")
+	boltWordsBucket = []byte("words")
+	boltMetaBucket  = []byte("meta")
+	boltHeaderKey   = []byte("header")
+)
+
+// boltBatchSize is the number of keys a BoltStore writes per transaction when a tree is built.
+var boltBatchSize = 1 << 16
+
+// BoltStore is a node store that persists a tree in a bbolt key-value database, so the tree is saved
+// incrementally as it is updated instead of being written as a whole. A new tree is written in sorted batches of
+// at most boltBatchSize keys per transaction, and its parameters are written last, so a tree that was not written
+// completely cannot be reopened. Nodes written with Put by updates are buffered until Flush, which the tree calls
+// after every update, so every update is written in a single transaction. The database also holds the parameters
+// of the tree and its bloom filter words, and the tree can be reopened with OpenBloomTree. Nodes are keyed by
+// their level and index, 4 and 8 bytes big endian.
+type BoltStore struct {
+	db *bolt.DB
+	// words and nodes buffer the changes of an update until Flush.
+	words map[int]uint64
+	nodes map[[12]byte][32]byte
+}
+
+// OpenBoltStore opens the bbolt database at path, creating it if it does not exist.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltNodesBucket, boltWordsBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db, words: make(map[int]uint64), nodes: make(map[[12]byte][32]byte)}, nil
+}
+
+func boltNodeKey(level int, index uint64) [12]byte {
+	var key [12]byte
+	binary.BigEndian.PutUint32(key[:4], uint32(level))
+	binary.BigEndian.PutUint64(key[4:], index)
+	return key
+}
+
+func boltWordKey(i int) []byte {
+	return appendUint64(nil, uint64(i))
+}
+
+// Get returns the node at the given level and index.
+func (s *BoltStore) Get(level int, index uint64) ([32]byte, error) {
+	key := boltNodeKey(level, index)
+	if node, ok := s.nodes[key]; ok {
+		return node, nil
+	}
+	var node [32]byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltNodesBucket).Get(key[:])
+		if len(v) != len(node) {
+			return fmt.Errorf("the store has no node at level %d and index %d", level, index)
+		}
+		copy(node[:], v)
+		return nil
+	})
+	return node, err
+}
+
+// Put overwrites the node at the given level and index. The node is written on the next Flush.
+func (s *BoltStore) Put(level int, index uint64, node [32]byte) error {
+	s.nodes[boltNodeKey(level, index)] = node
+	return nil
+}
+
+// Flush writes the buffered nodes and words in a single transaction, in the order of their keys.
+func (s *BoltStore) Flush() error {
+	if len(s.words) == 0 && len(s.nodes) == 0 {
+		return nil
+	}
+	indices := make([]int, 0, len(s.words))
+	for i := range s.words {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	keys := make([][12]byte, 0, len(s.nodes))
+	for key := range s.nodes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i][:], keys[j][:]) < 0 })
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		words := tx.Bucket(boltWordsBucket)
+		for _, i := range indices {
+			if err := words.Put(boltWordKey(i), appendUint64(nil, s.words[i])); err != nil {
+				return err
+			}
+		}
+		nodes := tx.Bucket(boltNodesBucket)
+		for _, key := range keys {
+			k, v := key, s.nodes[key]
+			if err := nodes.Put(k[:], v[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.words = make(map[int]uint64)
+	s.nodes = make(map[[12]byte][32]byte)
+	return nil
+}
+
+// Close closes the database. Nodes that were not flushed are discarded.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// putTree writes a new tree in batches of at most boltBatchSize keys. The words and nodes left behind by a tree
+// that was not written completely are dropped first, then the words and the nodes are written in the order of
+// their keys, and the header last.
+func (s *BoltStore) putTree(params Params, ref [32]byte, words []uint64, shape treeShape, nodes [][32]byte) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltMetaBucket).Get(boltHeaderKey) != nil {
+			return errors.New("the bolt store already holds a tree")
+		}
+		for _, name := range [][]byte{boltWordsBucket, boltNodesBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(words); start += boltBatchSize {
+		end := start + boltBatchSize
+		if end > len(words) {
+			end = len(words)
+		}
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(boltWordsBucket)
+			for i := start; i < end; i++ {
+				if err := b.Put(boltWordKey(i), appendUint64(nil, words[i])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for level, size := range shape.sizes {
+		for start := 0; start < size; start += boltBatchSize {
+			end := start + boltBatchSize
+			if end > size {
+				end = size
+			}
+			err := s.db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(boltNodesBucket)
+				for pos := start; pos < end; pos++ {
+					key := boltNodeKey(level, uint64(pos))
+					node := nodes[shape.index(level, uint64(pos))]
+					if err := b.Put(key[:], node[:]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Put(boltHeaderKey, appendTreeHeader([]byte{treeFileVersion}, params, ref))
+	})
+}
+
+func (s *BoltStore) putWord(i int, w uint64) {
+	s.words[i] = w
+}
+
+func (s *BoltStore) loadTree() (Params, [32]byte, []uint64, error) {
+	var params Params
+	var ref [32]byte
+	var words []uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		header := tx.Bucket(boltMetaBucket).Get(boltHeaderKey)
+		if header == nil {
+			return errors.New("the bolt store holds no tree")
+		}
+		br := &byteReader{data: header}
+		if version := br.byte(); br.err == nil && version != treeFileVersion {
+			return fmt.Errorf("unsupported bloom tree encoding version %d", version)
+		}
+		params, ref = readTreeHeader(br)
+		if br.err != nil {
+			return br.err
+		}
+		c := tx.Bucket(boltWordsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(k) != 8 || len(v) != 8 || binary.BigEndian.Uint64(k) != uint64(len(words)) {
+				return errors.New("the words of the bolt store are corrupted")
+			}
+			words = append(words, binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return params, ref, words, err
+}
@@ -0,0 +1,158 @@
+package bloomtree
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labbloom/DBF"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	seed := "secret seed"
+	defer func(n int) { boltBatchSize = n }(boltBatchSize)
+	var tests = []struct {
+		name      string
+		opts      []Option
+		batchSize int
+	}{
+		{name: "padded layout", opts: []Option{WithChunkSize(128)}, batchSize: 1 << 16},
+		{name: "balanced layout", opts: []Option{WithLayout(BalancedLayout), WithHashMode(HardenedHashMode), WithHasher(BLAKE3)}, batchSize: 1 << 16},
+		{name: "small batches", opts: []Option{WithLayout(BalancedLayout)}, batchSize: 5},
+	}
+	for _, test := range tests {
+		boltBatchSize = test.batchSize
+		path := filepath.Join(dir, test.name)
+		store, err := OpenBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbf := generateDBF(3000, seed, []byte{1}, []byte{2})
+		tree, err := NewBloomTree(dbf, append(test.opts, WithNodeStore(store))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Add([]byte{3}, []byte{4}); err != nil {
+			t.Fatal(err)
+		}
+		root := tree.Root()
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// the update was persisted without writing the tree as a whole
+		store, err = OpenBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewBloomTree(dbf, WithNodeStore(store)); err == nil {
+			t.Fatalf("expected error for a second tree in the store of the %s", test.name)
+		}
+		if _, err := OpenBloomTree(DBF.NewDbf(3000, 0.2, []byte("other seed")), store); err == nil {
+			t.Fatalf("expected error for a bloom filter with another seed for the %s", test.name)
+		}
+		bf := DBF.NewDbf(3000, 0.2, []byte(seed))
+		reopened, err := OpenBloomTree(bf, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reopened.Root() != root {
+			t.Fatalf("expected root %s for the reopened tree of the %s, but got %s", root, test.name, reopened.Root())
+		}
+		if !bf.BitArray().Equal(dbf.BitArray()) {
+			t.Fatalf("expected the bits of the %s to be restored", test.name)
+		}
+		for _, elem := range [][]byte{{1}, {4}, {9}} {
+			proof, err := reopened.GenerateCompactMultiProof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := VerifyCompactMultiProof(elem, []byte(seed), proof, root, dbf, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			} else if !verified {
+				t.Fatalf("failed to verify proof of element %v for the reopened %s", elem, test.name)
+			}
+		}
+		if err := reopened.Add([]byte{5}); err != nil {
+			t.Fatal(err)
+		}
+		rebuilt, err := NewBloomTree(bf, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reopened.Root() != rebuilt.Root() {
+			t.Fatalf("expected root %s after updating the reopened %s, but got %s", rebuilt.Root(), test.name, reopened.Root())
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBoltStoreIncompleteTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenBoltStore(filepath.Join(dir, "tree"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	seed := "secret seed"
+	if _, err := NewBloomTree(generateDBF(3000, seed, []byte{1}), WithNodeStore(store)); err != nil {
+		t.Fatal(err)
+	}
+	// the header is written last, so a build that stopped before it leaves no tree behind
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Delete(boltHeaderKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBloomTree(DBF.NewDbf(3000, 0.2, []byte(seed)), store); err == nil {
+		t.Fatal("expected error for a store without a complete tree")
+	}
+	dbf := generateDBF(200, seed, []byte{2})
+	tree, err := NewBloomTree(dbf, WithNodeStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf := DBF.NewDbf(200, 0.2, []byte(seed))
+	reopened, err := OpenBloomTree(bf, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Root() != tree.Root() {
+		t.Fatalf("expected root %s for the reopened tree, but got %s", tree.Root(), reopened.Root())
+	}
+}
+
+func TestOpenBloomTreeInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenBoltStore(filepath.Join(dir, "empty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bf := DBF.NewDbf(200, 0.2, []byte("secret seed"))
+	if _, err := OpenBloomTree(bf, store); err == nil {
+		t.Fatal("expected error for an empty store")
+	}
+	if _, err := OpenBloomTree(bf, newMemoryStore()); err == nil {
+		t.Fatal("expected error for a store that does not persist trees")
+	}
+}
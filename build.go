@@ -0,0 +1,62 @@
+package bloomtree
+
+import (
+	"sync"
+)
+
+// minParallelNodes is the smallest number of nodes of a level that is split across workers. Smaller levels
+// are hashed by the calling goroutine.
+const minParallelNodes = 256
+
+// buildNodes hashes the leaves of the bloom filter and all levels above them into nodes with the given number of
+// workers. The nodes do not depend on the number of workers.
+func buildNodes(th treeHasher, shape treeShape, bfAsInt []uint64, leafCount, workers int, nodes [][32]byte) {
+	step := th.chunkSize / 64
+	forEachRange(shape.sizes[0], workers, func(start, end int) {
+		for i := start; i < end; i++ {
+			if i >= leafCount {
+				nodes[i] = th.padding(uint64(i))
+				continue
+			}
+			first := i * step
+			last := first + step
+			if last > len(bfAsInt) {
+				last = len(bfAsInt)
+			}
+			nodes[i] = th.leaf(uint64(i), bfAsInt[first:last]...)
+		}
+	})
+	for level := 1; level < len(shape.sizes); level++ {
+		forEachRange(shape.sizes[level], workers, func(start, end int) {
+			for pos := start; pos < end; pos++ {
+				nodes[shape.index(level, uint64(pos))] = parentHash(th, shape, nodes, level-1, uint64(2*pos))
+			}
+		})
+	}
+}
+
+// forEachRange splits [0, n) into one range per worker and calls fn for every range, each in its own goroutine.
+// It returns after all calls returned.
+func forEachRange(n, workers int, fn func(start, end int)) {
+	if workers <= 1 || n < minParallelNodes {
+		fn(0, n)
+		return
+	}
+	if workers > n {
+		workers = n
+	}
+	size := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,99 @@
+package bloomtree
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParallelBuild(t *testing.T) {
+	var elements [][]byte
+	for i := 0; i < 3000; i++ {
+		elements = append(elements, []byte{byte(i), byte(i >> 8)})
+	}
+	dbf := generateDBF(10000, "secret seed", elements...)
+	var tests = []struct {
+		chunkSize int
+		mode      HashMode
+		layout    Layout
+	}{
+		{chunkSize: 64, mode: LegacyHashMode, layout: PaddedLayout},
+		{chunkSize: 64, mode: HardenedHashMode, layout: BalancedLayout},
+		{chunkSize: 128, mode: HardenedHashMode, layout: PaddedLayout},
+	}
+
+	for _, test := range tests {
+		sequential, err := NewBloomTree(dbf, WithChunkSize(test.chunkSize), WithHashMode(test.mode), WithLayout(test.layout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{2, 3, 8, 1000} {
+			parallel, err := NewBloomTree(dbf, WithChunkSize(test.chunkSize), WithHashMode(test.mode), WithLayout(test.layout), WithWorkers(workers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			parallelNodes, sequentialNodes := nodesOf(parallel), nodesOf(sequential)
+			if len(parallelNodes) != len(sequentialNodes) {
+				t.Fatalf("expected %d nodes, but got %d", len(sequentialNodes), len(parallelNodes))
+			}
+			for i := range sequentialNodes {
+				if parallelNodes[i] != sequentialNodes[i] {
+					t.Fatalf("expected node %d built with %d workers to match the sequential build", i, workers)
+				}
+			}
+		}
+	}
+
+	if _, err := NewBloomTree(dbf, WithWorkers(0)); err == nil {
+		t.Fatal("expected error for 0 workers")
+	}
+}
+
+func benchmarkFilter(b *testing.B) BloomFilter {
+	f, err := NewStandardFilter(1<<24, 7, []byte("secret seed"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 100000; i++ {
+		f.Add([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+	}
+	return f
+}
+
+func BenchmarkNewBloomTreeSequential(b *testing.B) {
+	f := benchmarkFilter(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewBloomTree(f, WithHashMode(HardenedHashMode)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewBloomTreeParallel(b *testing.B) {
+	f := benchmarkFilter(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewBloomTree(f, WithHashMode(HardenedHashMode), WithWorkers(runtime.NumCPU())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkNewBloomTreeWords measures the copy of the bloom filter words the tree keeps, and reports its size
+// next to the size of the nodes.
+func BenchmarkNewBloomTreeWords(b *testing.B) {
+	f := benchmarkFilter(b)
+	tree, err := NewBloomTree(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	shape := tree.shape
+	words := f.BitArray().Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := make([]uint64, len(words))
+		copy(c, words)
+	}
+	b.ReportMetric(float64(8*len(words)), "words-bytes")
+	b.ReportMetric(float64(32*shape.len()), "nodes-bytes")
+}
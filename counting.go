@@ -0,0 +1,72 @@
+package bloomtree
+
+import (
+	"errors"
+)
+
+// maxCount is the value at which a counter of a CountingFilter saturates.
+const maxCount = ^uint8(0)
+
+// CountingFilter is a bloom filter with a counter per bit, so elements can be removed again. It maps elements
+// with the same keyed double hashing as StandardFilter. The bit array of the filter is the set of counters
+// that are not zero. Counters saturate at 255 and are never decremented once saturated, so removing elements
+// never introduces false negatives.
+type CountingFilter struct {
+	keyedFilter
+	counts []uint8
+}
+
+// NewCountingFilter creates an empty counting bloom filter of m counters and k hash functions, keyed with the seed.
+func NewCountingFilter(m, k uint, seed []byte) (*CountingFilter, error) {
+	f, err := newKeyedFilter(m, k, seed, DoubleHashingIndexScheme)
+	if err != nil {
+		return nil, err
+	}
+	return &CountingFilter{keyedFilter: f, counts: make([]uint8, m)}, nil
+}
+
+// Add inserts the element into the bloom filter and increments its counters.
+func (f *CountingFilter) Add(element []byte) {
+	for _, index := range f.GetElementIndices(element) {
+		if f.counts[index] == maxCount {
+			continue
+		}
+		f.counts[index]++
+		if f.counts[index] == 1 {
+			f.b.Set(index)
+		}
+	}
+}
+
+// Remove deletes the element from the bloom filter. It returns an error and leaves the bloom filter unchanged
+// if the element is not in the bloom filter.
+func (f *CountingFilter) Remove(element []byte) error {
+	indices := f.GetElementIndices(element)
+	needed := make(map[uint]int, len(indices))
+	for _, index := range indices {
+		needed[index]++
+	}
+	for index, n := range needed {
+		if f.counts[index] != maxCount && int(f.counts[index]) < n {
+			return errors.New("the element is not in the bloom filter")
+		}
+	}
+	for _, index := range indices {
+		if f.counts[index] == maxCount {
+			continue
+		}
+		f.counts[index]--
+		if f.counts[index] == 0 {
+			f.b.Clear(index)
+		}
+	}
+	return nil
+}
+
+// Count returns the counter at the given index.
+func (f *CountingFilter) Count(index uint) uint8 {
+	if index >= f.m {
+		return 0
+	}
+	return f.counts[index]
+}
@@ -0,0 +1,128 @@
+package bloomtree
+
+import (
+	"testing"
+)
+
+func TestCountingFilter(t *testing.T) {
+	f, err := NewCountingFilter(1000, 4, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Add([]byte("Foo"))
+	f.Add([]byte("Foo"))
+	f.Add([]byte("Bar"))
+	for _, index := range f.GetElementIndices([]byte("Foo")) {
+		if f.Count(index) < 2 {
+			t.Fatalf("expected counter %d to be at least 2, but got %d", index, f.Count(index))
+		}
+	}
+	if err := f.Remove([]byte("Foo")); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Test([]byte("Foo")) {
+		t.Fatal("expected element added twice to stay after one removal")
+	}
+	if err := f.Remove([]byte("Foo")); err != nil {
+		t.Fatal(err)
+	}
+	if f.Test([]byte("Foo")) {
+		t.Fatal("expected removed element to be absent")
+	}
+	if !f.Test([]byte("Bar")) {
+		t.Fatal("expected element to stay after removing another element")
+	}
+	if err := f.Remove([]byte("Foo")); err == nil {
+		t.Fatal("expected error for removing an absent element")
+	}
+	if f.BitArray().Count() != uint(len(uniqueUints(f.GetElementIndices([]byte("Bar"))))) {
+		t.Fatalf("expected the bit array to only contain the bits of the remaining element")
+	}
+	if _, err := NewCountingFilter(0, 4, nil); err == nil {
+		t.Fatal("expected error for a bloom filter without bits")
+	}
+}
+
+func TestCountingFilterSaturation(t *testing.T) {
+	f, err := NewCountingFilter(64, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		f.Add([]byte("Foo"))
+	}
+	for i := 0; i < 300; i++ {
+		if err := f.Remove([]byte("Foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !f.Test([]byte("Foo")) {
+		t.Fatal("expected saturated counters to never reach zero")
+	}
+}
+
+func TestBloomTreeRemove(t *testing.T) {
+	seed := []byte("secret seed")
+	f, err := NewCountingFilter(1000, 4, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		f.Add([]byte{byte(i)})
+	}
+	tree, err := NewBloomTree(f, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := []byte{7}
+	if err := tree.Remove(removed, []byte{8}); err != nil {
+		t.Fatal(err)
+	}
+	full, err := NewBloomTree(f, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Root() != full.Root() {
+		t.Fatalf("expected root %s after removal, but got %s", full.Root(), tree.Root())
+	}
+	if f.Test(removed) {
+		t.Fatal("expected removed element to be absent")
+	}
+	proof, err := tree.GenerateCompactMultiProof(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(tree.Root(), tree.Params(), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := verifier.Verify(removed, proof)
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified || CheckProofType(proof.ProofType) {
+		t.Fatal("failed to verify absence proof of a removed element")
+	}
+
+	if err := tree.Remove(removed); err == nil {
+		t.Fatal("expected error for removing an absent element")
+	}
+	other, err := NewBloomTree(generateDBF(200, string(seed), []byte{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Remove([]byte{1}); err == nil {
+		t.Fatal("expected error for a bloom filter without a Remove method")
+	}
+}
+
+func uniqueUints(values []uint) []uint {
+	seen := make(map[uint]bool)
+	var ret []uint
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,96 @@
+package bloomtree
+
+import (
+	"errors"
+)
+
+// NodeSource returns the node hashes of a bloom tree, for example of a remote replica. Level 0 holds the leaves,
+// the last level holds the root. BloomTree implements NodeSource.
+type NodeSource interface {
+	Params() Params
+	Nodes(level int, indices []uint64) ([][32]byte, error)
+}
+
+// Nodes returns the nodes at the given indices of a level.
+func (bt *BloomTree) Nodes(level int, indices []uint64) ([][32]byte, error) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	return bt.nodesAt(level, indices)
+}
+
+func (bt *BloomTree) nodesAt(level int, indices []uint64) ([][32]byte, error) {
+	nodes := make([][32]byte, len(indices))
+	for i, index := range indices {
+		node, err := bt.node(level, index)
+		if err != nil {
+			return nil, err
+		}
+		nodes[i] = node
+	}
+	return nodes, nil
+}
+
+// Diff compares the tree with another tree of the same parameters top-down and returns the sorted indices of
+// the chunks whose leaves differ. It only descends into subtrees whose roots differ, so it requests one level
+// of nodes of the other tree at a time, and only the nodes below a mismatch. The tree is only locked while the
+// nodes of a level are copied, not while the other tree is queried, so if the tree is updated during the diff,
+// the result may mix its states before and after the update.
+func (bt *BloomTree) Diff(other NodeSource) ([]uint64, error) {
+	indices, _, _, err := bt.diff(other)
+	return indices, err
+}
+
+// diff returns the indices of the differing chunks, the leaves of the other tree at those indices and the root
+// of the other tree.
+func (bt *BloomTree) diff(other NodeSource) ([]uint64, [][32]byte, Root, error) {
+	if t, ok := other.(*BloomTree); ok && t == bt {
+		return nil, nil, bt.Root(), nil
+	}
+	if other.Params() != bt.Params() {
+		return nil, nil, Root{}, errors.New("the trees have different parameters")
+	}
+	shape := bt.shape
+	var root Root
+	candidates := []uint64{0}
+	for level := shape.height(); ; level-- {
+		local, err := bt.Nodes(level, candidates)
+		if err != nil {
+			return nil, nil, Root{}, err
+		}
+		remote, err := other.Nodes(level, candidates)
+		if err != nil {
+			return nil, nil, Root{}, err
+		}
+		if len(remote) != len(candidates) {
+			return nil, nil, Root{}, errors.New("the other tree returned a wrong number of nodes")
+		}
+		if level == shape.height() {
+			root = remote[0]
+		}
+		var differing []uint64
+		var nodes [][32]byte
+		for i, pos := range candidates {
+			if local[i] != remote[i] {
+				differing = append(differing, pos)
+				nodes = append(nodes, remote[i])
+			}
+		}
+		if level == 0 || len(differing) == 0 {
+			return differing, nodes, root, nil
+		}
+		candidates = childPositions(shape, level, differing)
+	}
+}
+
+// childPositions returns the sorted positions of the children of the given sorted nodes of a level.
+func childPositions(shape treeShape, level int, positions []uint64) []uint64 {
+	size := uint64(shape.sizes[level-1])
+	var children []uint64
+	for _, pos := range positions {
+		children = append(children, 2*pos)
+		if 2*pos+1 < size {
+			children = append(children, 2*pos+1)
+		}
+	}
+	return children
+}
@@ -0,0 +1,140 @@
+package bloomtree
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// countingSource counts the nodes requested from a tree.
+type countingSource struct {
+	*BloomTree
+	requested int
+}
+
+func (s *countingSource) Nodes(level int, indices []uint64) ([][32]byte, error) {
+	s.requested += len(indices)
+	return s.BloomTree.Nodes(level, indices)
+}
+
+func TestDiff(t *testing.T) {
+	seed := []byte("secret seed")
+	var tests = []struct {
+		name string
+		opts []Option
+	}{
+		{name: "padded layout", opts: nil},
+		{name: "balanced layout", opts: []Option{WithLayout(BalancedLayout), WithHashMode(HardenedHashMode)}},
+	}
+	for _, test := range tests {
+		a, err := NewStandardFilter(5000, 3, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewStandardFilter(5000, 3, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 30; i++ {
+			a.Add([]byte{byte(i)})
+			b.Add([]byte{byte(i)})
+		}
+		local, err := NewBloomTree(a, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, err := NewBloomTree(b, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diff, err := local.Diff(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diff) != 0 {
+			t.Fatalf("expected no differing chunks for the %s, but got %v", test.name, diff)
+		}
+
+		// the last chunk is shorter than the others and has no sibling in the balanced layout
+		var changed []uint
+		var want []uint64
+		for _, bit := range []uint{3, 1000, 1001, 4999} {
+			if a.BitArray().Test(bit) {
+				continue
+			}
+			changed = append(changed, bit)
+			if index := uint64(bit) / defaultChunkSize; len(want) == 0 || want[len(want)-1] != index {
+				want = append(want, index)
+			}
+			b.BitArray().Set(bit)
+		}
+		if err := remote.Refresh(changed); err != nil {
+			t.Fatal(err)
+		}
+		source := &countingSource{BloomTree: remote}
+		diff, err = local.Diff(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(diff, want) {
+			t.Fatalf("expected differing chunks %v for the %s, but got %v", want, test.name, diff)
+		}
+		if source.requested >= local.shape.len()/2 {
+			t.Fatalf("expected the diff of the %s to request few nodes, but it requested %d", test.name, source.requested)
+		}
+		reverse, err := remote.Diff(local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reverse, want) {
+			t.Fatalf("expected the reverse diff %v for the %s, but got %v", want, test.name, reverse)
+		}
+	}
+
+	other, err := NewBloomTree(generateDBF(500, "secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewBloomTree(generateDBF(600, "secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Diff(other); err == nil {
+		t.Fatal("expected error for trees with different parameters")
+	}
+}
+
+func TestDiffConcurrent(t *testing.T) {
+	// diffs in both directions must not deadlock while writers wait for the trees
+	a, err := NewBloomTree(generateDBF(500, "secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBloomTree(generateDBF(500, "secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for _, trees := range [][2]*BloomTree{{a, b}, {b, a}} {
+		wg.Add(2)
+		go func(trees [2]*BloomTree) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if _, err := trees[0].Diff(trees[1]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(trees)
+		go func(tree *BloomTree) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if err := tree.Add([]byte{byte(i)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(trees[0])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,812 @@
+package bloomtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// proofEncodingVersion is the version of the binary encoding of compact multiproofs of trees with the padded layout.
+const proofEncodingVersion = byte(1)
+
+// layoutEncodingVersion is the version of the binary encoding of compact multiproofs of trees with another layout.
+// It appends the layout to the encoding of version 1.
+const layoutEncodingVersion = byte(2)
+
+// encodingVersion returns the encoding version of a proof for a tree with the given layout.
+func encodingVersion(l Layout) byte {
+	if l == PaddedLayout {
+		return proofEncodingVersion
+	}
+	return layoutEncodingVersion
+}
+
+// checkEncodingVersion checks that a decoded version matches the decoded layout.
+func checkEncodingVersion(version uint64, l Layout) error {
+	if version != uint64(proofEncodingVersion) && version != uint64(layoutEncodingVersion) {
+		return fmt.Errorf("unsupported proof encoding version %d", version)
+	}
+	if version != uint64(encodingVersion(l)) {
+		return fmt.Errorf("the layout %v cannot be encoded with version %d", l, version)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the proof in its canonical binary form. All integers are big endian:
+//
+//	version      1 byte
+//	proof type   1 byte
+//	chunk count  4 bytes, followed by every chunk as
+//	  word count 4 bytes
+//	  words      8 bytes each
+//	hash count   4 bytes
+//	hashes       32 bytes each
+//	chunk size   4 bytes
+//	hasher       1 byte
+//	hash mode    1 byte
+//	layout       1 byte, only in version 2
+//
+// Proofs of trees with the padded layout are encoded with version 1, all others with version 2.
+// Equal proofs always have the same encoding, so the encoding can be hashed or signed.
+func (multiproof *CompactMultiProof) MarshalBinary() ([]byte, error) {
+	if err := multiproof.validateEncoding(); err != nil {
+		return nil, err
+	}
+	version := encodingVersion(multiproof.Layout)
+	size := 1 + 1 + 4 + 4 + len(multiproof.Proof)*32 + 4 + 1 + 1 + 1
+	for _, chunk := range multiproof.Chunks {
+		size += 4 + len(chunk)*8
+	}
+	data := make([]byte, 0, size)
+	data = append(data, version, multiproof.ProofType)
+	data = appendUint32(data, uint32(len(multiproof.Chunks)))
+	for _, chunk := range multiproof.Chunks {
+		data = appendUint32(data, uint32(len(chunk)))
+		for _, word := range chunk {
+			data = appendUint64(data, word)
+		}
+	}
+	data = appendUint32(data, uint32(len(multiproof.Proof)))
+	for _, hash := range multiproof.Proof {
+		data = append(data, hash[:]...)
+	}
+	data = appendUint32(data, uint32(multiproof.ChunkSize))
+	data = append(data, byte(multiproof.Hasher), byte(multiproof.HashMode))
+	if version == layoutEncodingVersion {
+		data = append(data, byte(multiproof.Layout))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary. It rejects unknown versions, invalid tree
+// parameters, chunks that do not fit the chunk size and trailing bytes.
+func (multiproof *CompactMultiProof) UnmarshalBinary(data []byte) error {
+	r := &byteReader{data: data}
+	version := r.byte()
+	if r.err == nil && version != proofEncodingVersion && version != layoutEncodingVersion {
+		return fmt.Errorf("unsupported proof encoding version %d", version)
+	}
+	var p CompactMultiProof
+	p.ProofType = r.byte()
+	chunkCount := r.count(4)
+	if chunkCount > 0 {
+		p.Chunks = make([][]uint64, chunkCount)
+	}
+	for i := range p.Chunks {
+		wordCount := r.count(8)
+		if r.err != nil {
+			break
+		}
+		chunk := make([]uint64, wordCount)
+		for j := range chunk {
+			chunk[j] = r.uint64()
+		}
+		p.Chunks[i] = chunk
+	}
+	hashCount := r.count(32)
+	if hashCount > 0 {
+		p.Proof = make([][32]byte, hashCount)
+	}
+	for i := range p.Proof {
+		copy(p.Proof[i][:], r.next(32))
+	}
+	chunkSize := r.uint32()
+	p.ChunkSize = int(chunkSize)
+	p.Hasher = Hasher(r.byte())
+	p.HashMode = HashMode(r.byte())
+	if version == layoutEncodingVersion {
+		p.Layout = Layout(r.byte())
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if err := checkEncodingVersion(uint64(version), p.Layout); err != nil {
+		return err
+	}
+	if len(r.data) != 0 {
+		return errors.New("the encoded proof has trailing bytes")
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	*multiproof = p
+	return nil
+}
+
+// validateEncoding checks the invariants every encoded proof must satisfy.
+func (multiproof *CompactMultiProof) validateEncoding() error {
+	if err := validChunkSize(multiproof.ChunkSize); err != nil {
+		return err
+	}
+	if !multiproof.Hasher.Valid() {
+		return fmt.Errorf("unknown hasher %v", multiproof.Hasher)
+	}
+	if !multiproof.HashMode.Valid() {
+		return fmt.Errorf("unknown hash mode %v", multiproof.HashMode)
+	}
+	if !multiproof.Layout.Valid() {
+		return fmt.Errorf("unknown layout %v", multiproof.Layout)
+	}
+	if len(multiproof.Chunks) == 0 {
+		return errors.New("the proof must contain at least 1 chunk")
+	}
+	if err := validateProofType(uint64(multiproof.ProofType), len(multiproof.Chunks)); err != nil {
+		return err
+	}
+	for _, chunk := range multiproof.Chunks {
+		if len(chunk) == 0 || len(chunk) > multiproof.ChunkSize/64 {
+			return errors.New("the chunk has an invalid length")
+		}
+	}
+	if uint64(len(multiproof.Proof)) > math.MaxUint32 {
+		return errors.New("the proof has too many hashes to be encoded")
+	}
+	return nil
+}
+
+// validateProofType checks that a decoded proof type fits into a byte and that absence proofs carry exactly
+// one chunk.
+func validateProofType(proofType uint64, chunks int) error {
+	if proofType > uint64(maxK) {
+		return fmt.Errorf("the proof type must not exceed %d", maxK)
+	}
+	if uint8(proofType) != maxK && chunks != 1 {
+		return errors.New("an absence proof must contain exactly 1 chunk")
+	}
+	return nil
+}
+
+func appendUint32(data []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(data, b[:]...)
+}
+
+func appendUint64(data []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(data, b[:]...)
+}
+
+// byteReader reads big endian values from a byte slice. After the first error, all reads return zero values.
+type byteReader struct {
+	data []byte
+	err  error
+}
+
+func (r *byteReader) next(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.data) < n {
+		r.err = errors.New("the encoded data is too short")
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *byteReader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *byteReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.next(4))
+}
+
+func (r *byteReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.next(8))
+}
+
+// count reads a 4 byte length prefix of items of the given size and checks that they fit into the remaining data.
+func (r *byteReader) count(itemSize int) int {
+	n := r.uint32()
+	if r.err == nil && uint64(n)*uint64(itemSize) > uint64(len(r.data)) {
+		r.err = errors.New("the encoded data is too short")
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+var (
+	cborEncMode cbor.EncMode
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	var err error
+	// core deterministic encoding, so equal values always have the same encoding
+	cborEncMode, err = cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		panic(err)
+	}
+	cborDecMode, err = cbor.DecOptions{
+		DupMapKey:         cbor.DupMapKeyEnforcedAPF,
+		ExtraReturnErrors: cbor.ExtraDecErrorUnknownField,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Root is the root hash of a bloom tree. It encodes as a hex string in JSON and as a byte string in CBOR.
+type Root [32]byte
+
+func (r Root) String() string {
+	return hex.EncodeToString(r[:])
+}
+
+// MarshalText encodes the root as a hex string.
+func (r Root) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText decodes a root from a hex string.
+func (r *Root) UnmarshalText(text []byte) error {
+	h, err := decodeHexHash(string(text))
+	if err != nil {
+		return err
+	}
+	*r = h
+	return nil
+}
+
+// MarshalCBOR encodes the root as a CBOR byte string.
+func (r Root) MarshalCBOR() ([]byte, error) {
+	return cborEncMode.Marshal(r[:])
+}
+
+// UnmarshalCBOR decodes a root from a CBOR byte string of 32 bytes.
+func (r *Root) UnmarshalCBOR(data []byte) error {
+	var b []byte
+	if err := cborDecMode.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	if len(b) != len(r) {
+		return fmt.Errorf("a hash must be %d bytes long, but got %d", len(r), len(b))
+	}
+	copy(r[:], b)
+	return nil
+}
+
+func decodeHexHash(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != len(h) {
+		return h, fmt.Errorf("a hash must be %d bytes long, but got %d", len(h), len(b))
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// proofJSON is the JSON representation of a compact multiproof. Chunks are hex strings of their big endian
+// words and proof hashes are hex strings.
+type proofJSON struct {
+	Version   int      `json:"version"`
+	ProofType int      `json:"proofType"`
+	Chunks    []string `json:"chunks"`
+	Proof     []string `json:"proof"`
+	ChunkSize int      `json:"chunkSize"`
+	Hasher    Hasher   `json:"hasher"`
+	HashMode  HashMode `json:"hashMode"`
+	Layout    Layout   `json:"layout,omitempty"`
+}
+
+// MarshalJSON encodes the proof as a JSON object with the fields version, proofType, chunks, proof, chunkSize,
+// hasher and hashMode. Proofs of trees with a layout other than the padded one have version 2 and the
+// additional field layout.
+func (multiproof *CompactMultiProof) MarshalJSON() ([]byte, error) {
+	if err := multiproof.validateEncoding(); err != nil {
+		return nil, err
+	}
+	v := proofJSON{
+		Version:   int(encodingVersion(multiproof.Layout)),
+		ProofType: int(multiproof.ProofType),
+		Chunks:    make([]string, len(multiproof.Chunks)),
+		Proof:     make([]string, len(multiproof.Proof)),
+		ChunkSize: multiproof.ChunkSize,
+		Hasher:    multiproof.Hasher,
+		HashMode:  multiproof.HashMode,
+		Layout:    multiproof.Layout,
+	}
+	for i, chunk := range multiproof.Chunks {
+		v.Chunks[i] = encodeChunkHex(chunk)
+	}
+	for i, hash := range multiproof.Proof {
+		v.Proof[i] = hex.EncodeToString(hash[:])
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a proof encoded by MarshalJSON. Unknown fields, invalid hashes and invalid tree
+// parameters are rejected.
+func (multiproof *CompactMultiProof) UnmarshalJSON(data []byte) error {
+	var v proofJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("the encoded proof has trailing data")
+	}
+	if v.Version < 0 {
+		return fmt.Errorf("unsupported proof encoding version %d", v.Version)
+	}
+	if err := checkEncodingVersion(uint64(v.Version), v.Layout); err != nil {
+		return err
+	}
+	if v.ProofType < 0 {
+		return errors.New("the proof type must not be negative")
+	}
+	if err := validateProofType(uint64(v.ProofType), len(v.Chunks)); err != nil {
+		return err
+	}
+	p := CompactMultiProof{
+		ProofType: uint8(v.ProofType),
+		ChunkSize: v.ChunkSize,
+		Hasher:    v.Hasher,
+		HashMode:  v.HashMode,
+		Layout:    v.Layout,
+	}
+	if len(v.Chunks) > 0 {
+		p.Chunks = make([][]uint64, len(v.Chunks))
+	}
+	for i, c := range v.Chunks {
+		chunk, err := decodeChunkHex(c)
+		if err != nil {
+			return err
+		}
+		p.Chunks[i] = chunk
+	}
+	if len(v.Proof) > 0 {
+		p.Proof = make([][32]byte, len(v.Proof))
+	}
+	for i, h := range v.Proof {
+		hash, err := decodeHexHash(h)
+		if err != nil {
+			return err
+		}
+		p.Proof[i] = hash
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	*multiproof = p
+	return nil
+}
+
+// encodeChunkHex encodes a chunk as the hex string of its big endian words.
+func encodeChunkHex(chunk []uint64) string {
+	b := make([]byte, 0, 8*len(chunk))
+	for _, word := range chunk {
+		b = appendUint64(b, word)
+	}
+	return hex.EncodeToString(b)
+}
+
+// decodeChunkHex decodes a chunk encoded by encodeChunkHex.
+func decodeChunkHex(s string) ([]uint64, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b)%8 != 0 {
+		return nil, errors.New("a chunk must consist of 8 byte words")
+	}
+	chunk := make([]uint64, len(b)/8)
+	for j := range chunk {
+		chunk[j] = binary.BigEndian.Uint64(b[8*j:])
+	}
+	return chunk, nil
+}
+
+// proofCBOR is the CBOR representation of a compact multiproof, it uses the same field names as proofJSON.
+type proofCBOR struct {
+	Version   uint64     `cbor:"version"`
+	ProofType uint64     `cbor:"proofType"`
+	Chunks    [][]uint64 `cbor:"chunks"`
+	Proof     [][]byte   `cbor:"proof"`
+	ChunkSize uint64     `cbor:"chunkSize"`
+	Hasher    uint64     `cbor:"hasher"`
+	HashMode  uint64     `cbor:"hashMode"`
+	Layout    uint64     `cbor:"layout,omitempty"`
+}
+
+// MarshalCBOR encodes the proof as a deterministic CBOR map with the same field names as the JSON encoding.
+// Proof hashes are byte strings, the hasher and the hash mode are their numeric values.
+func (multiproof *CompactMultiProof) MarshalCBOR() ([]byte, error) {
+	if err := multiproof.validateEncoding(); err != nil {
+		return nil, err
+	}
+	v := proofCBOR{
+		Version:   uint64(encodingVersion(multiproof.Layout)),
+		ProofType: uint64(multiproof.ProofType),
+		Chunks:    multiproof.Chunks,
+		Proof:     make([][]byte, len(multiproof.Proof)),
+		ChunkSize: uint64(multiproof.ChunkSize),
+		Hasher:    uint64(multiproof.Hasher),
+		HashMode:  uint64(multiproof.HashMode),
+		Layout:    uint64(multiproof.Layout),
+	}
+	for i := range multiproof.Proof {
+		v.Proof[i] = multiproof.Proof[i][:]
+	}
+	return cborEncMode.Marshal(v)
+}
+
+// UnmarshalCBOR decodes a proof encoded by MarshalCBOR. Unknown or duplicate fields, invalid hashes and invalid
+// tree parameters are rejected.
+func (multiproof *CompactMultiProof) UnmarshalCBOR(data []byte) error {
+	var v proofCBOR
+	if err := cborDecMode.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Layout > math.MaxUint8 {
+		return errors.New("invalid tree parameters")
+	}
+	if err := checkEncodingVersion(v.Version, Layout(v.Layout)); err != nil {
+		return err
+	}
+	if err := validateProofType(v.ProofType, len(v.Chunks)); err != nil {
+		return err
+	}
+	if v.ChunkSize > math.MaxUint32 || v.Hasher > math.MaxUint8 || v.HashMode > math.MaxUint8 {
+		return errors.New("invalid tree parameters")
+	}
+	p := CompactMultiProof{
+		ProofType: uint8(v.ProofType),
+		Chunks:    v.Chunks,
+		ChunkSize: int(v.ChunkSize),
+		Hasher:    Hasher(v.Hasher),
+		HashMode:  HashMode(v.HashMode),
+		Layout:    Layout(v.Layout),
+	}
+	if len(v.Proof) > 0 {
+		p.Proof = make([][32]byte, len(v.Proof))
+	}
+	for i, h := range v.Proof {
+		if len(h) != len(p.Proof[i]) {
+			return fmt.Errorf("a hash must be %d bytes long, but got %d", len(p.Proof[i]), len(h))
+		}
+		copy(p.Proof[i][:], h)
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	*multiproof = p
+	return nil
+}
+
+// batchEncodingVersion is the version of the binary encoding of batch proofs. Unlike compact multiproofs, batch
+// proofs always encode their layout.
+const batchEncodingVersion = byte(1)
+
+// MarshalBinary encodes the batch proof in its canonical binary form. All integers are big endian:
+//
+//	version           1 byte
+//	chunk count       4 bytes, followed by every chunk as
+//	  word count      4 bytes
+//	  words           8 bytes each
+//	hash count        4 bytes
+//	hashes            32 bytes each
+//	proof type count  4 bytes
+//	proof types       1 byte each
+//	chunk size        4 bytes
+//	hasher            1 byte
+//	hash mode         1 byte
+//	layout            1 byte
+//
+// Equal batch proofs always have the same encoding, so the encoding can be hashed or signed.
+func (batch *BatchProof) MarshalBinary() ([]byte, error) {
+	if err := batch.validateEncoding(); err != nil {
+		return nil, err
+	}
+	size := 1 + 4 + 4 + len(batch.Proof)*32 + 4 + len(batch.ProofTypes) + 4 + 1 + 1 + 1
+	for _, chunk := range batch.Chunks {
+		size += 4 + len(chunk)*8
+	}
+	data := make([]byte, 0, size)
+	data = append(data, batchEncodingVersion)
+	data = appendUint32(data, uint32(len(batch.Chunks)))
+	for _, chunk := range batch.Chunks {
+		data = appendUint32(data, uint32(len(chunk)))
+		for _, word := range chunk {
+			data = appendUint64(data, word)
+		}
+	}
+	data = appendUint32(data, uint32(len(batch.Proof)))
+	for _, hash := range batch.Proof {
+		data = append(data, hash[:]...)
+	}
+	data = appendUint32(data, uint32(len(batch.ProofTypes)))
+	data = append(data, batch.ProofTypes...)
+	data = appendUint32(data, uint32(batch.ChunkSize))
+	data = append(data, byte(batch.Hasher), byte(batch.HashMode), byte(batch.Layout))
+	return data, nil
+}
+
+// UnmarshalBinary decodes a batch proof encoded by MarshalBinary. It rejects unknown versions, invalid tree
+// parameters, chunks that do not fit the chunk size and trailing bytes.
+func (batch *BatchProof) UnmarshalBinary(data []byte) error {
+	r := &byteReader{data: data}
+	version := r.byte()
+	if r.err == nil && version != batchEncodingVersion {
+		return fmt.Errorf("unsupported batch proof encoding version %d", version)
+	}
+	var p BatchProof
+	chunkCount := r.count(4)
+	if chunkCount > 0 {
+		p.Chunks = make([][]uint64, chunkCount)
+	}
+	for i := range p.Chunks {
+		wordCount := r.count(8)
+		if r.err != nil {
+			break
+		}
+		chunk := make([]uint64, wordCount)
+		for j := range chunk {
+			chunk[j] = r.uint64()
+		}
+		p.Chunks[i] = chunk
+	}
+	hashCount := r.count(32)
+	if hashCount > 0 {
+		p.Proof = make([][32]byte, hashCount)
+	}
+	for i := range p.Proof {
+		copy(p.Proof[i][:], r.next(32))
+	}
+	proofTypeCount := r.count(1)
+	if proofTypeCount > 0 {
+		p.ProofTypes = make([]uint8, proofTypeCount)
+		copy(p.ProofTypes, r.next(proofTypeCount))
+	}
+	p.ChunkSize = int(r.uint32())
+	p.Hasher = Hasher(r.byte())
+	p.HashMode = HashMode(r.byte())
+	p.Layout = Layout(r.byte())
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.data) != 0 {
+		return errors.New("the encoded batch proof has trailing bytes")
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	*batch = p
+	return nil
+}
+
+// validateEncoding checks the invariants every encoded batch proof must satisfy.
+func (batch *BatchProof) validateEncoding() error {
+	if err := validChunkSize(batch.ChunkSize); err != nil {
+		return err
+	}
+	if !batch.Hasher.Valid() {
+		return fmt.Errorf("unknown hasher %v", batch.Hasher)
+	}
+	if !batch.HashMode.Valid() {
+		return fmt.Errorf("unknown hash mode %v", batch.HashMode)
+	}
+	if !batch.Layout.Valid() {
+		return fmt.Errorf("unknown layout %v", batch.Layout)
+	}
+	if len(batch.Chunks) == 0 {
+		return errors.New("the batch proof must contain at least 1 chunk")
+	}
+	if len(batch.ProofTypes) == 0 {
+		return errors.New("the batch proof must contain at least 1 proof type")
+	}
+	for _, chunk := range batch.Chunks {
+		if len(chunk) == 0 || len(chunk) > batch.ChunkSize/64 {
+			return errors.New("the chunk has an invalid length")
+		}
+	}
+	if uint64(len(batch.Chunks)) > math.MaxUint32 || uint64(len(batch.Proof)) > math.MaxUint32 ||
+		uint64(len(batch.ProofTypes)) > math.MaxUint32 {
+		return errors.New("the batch proof is too large to be encoded")
+	}
+	return nil
+}
+
+// batchJSON is the JSON representation of a batch proof. Chunks and proof hashes are encoded as in proofJSON.
+type batchJSON struct {
+	Version    int      `json:"version"`
+	Chunks     []string `json:"chunks"`
+	Proof      []string `json:"proof"`
+	ProofTypes []int    `json:"proofTypes"`
+	ChunkSize  int      `json:"chunkSize"`
+	Hasher     Hasher   `json:"hasher"`
+	HashMode   HashMode `json:"hashMode"`
+	Layout     Layout   `json:"layout"`
+}
+
+// MarshalJSON encodes the batch proof as a JSON object with the fields version, chunks, proof, proofTypes,
+// chunkSize, hasher, hashMode and layout.
+func (batch *BatchProof) MarshalJSON() ([]byte, error) {
+	if err := batch.validateEncoding(); err != nil {
+		return nil, err
+	}
+	v := batchJSON{
+		Version:    int(batchEncodingVersion),
+		Chunks:     make([]string, len(batch.Chunks)),
+		Proof:      make([]string, len(batch.Proof)),
+		ProofTypes: make([]int, len(batch.ProofTypes)),
+		ChunkSize:  batch.ChunkSize,
+		Hasher:     batch.Hasher,
+		HashMode:   batch.HashMode,
+		Layout:     batch.Layout,
+	}
+	for i, chunk := range batch.Chunks {
+		v.Chunks[i] = encodeChunkHex(chunk)
+	}
+	for i, hash := range batch.Proof {
+		v.Proof[i] = hex.EncodeToString(hash[:])
+	}
+	for i, proofType := range batch.ProofTypes {
+		v.ProofTypes[i] = int(proofType)
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a batch proof encoded by MarshalJSON. Unknown fields, invalid hashes and invalid tree
+// parameters are rejected.
+func (batch *BatchProof) UnmarshalJSON(data []byte) error {
+	var v batchJSON
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("the encoded batch proof has trailing data")
+	}
+	if v.Version != int(batchEncodingVersion) {
+		return fmt.Errorf("unsupported batch proof encoding version %d", v.Version)
+	}
+	p := BatchProof{
+		ChunkSize: v.ChunkSize,
+		Hasher:    v.Hasher,
+		HashMode:  v.HashMode,
+		Layout:    v.Layout,
+	}
+	if len(v.Chunks) > 0 {
+		p.Chunks = make([][]uint64, len(v.Chunks))
+	}
+	for i, c := range v.Chunks {
+		chunk, err := decodeChunkHex(c)
+		if err != nil {
+			return err
+		}
+		p.Chunks[i] = chunk
+	}
+	if len(v.Proof) > 0 {
+		p.Proof = make([][32]byte, len(v.Proof))
+	}
+	for i, h := range v.Proof {
+		hash, err := decodeHexHash(h)
+		if err != nil {
+			return err
+		}
+		p.Proof[i] = hash
+	}
+	if len(v.ProofTypes) > 0 {
+		p.ProofTypes = make([]uint8, len(v.ProofTypes))
+	}
+	for i, proofType := range v.ProofTypes {
+		if proofType < 0 || proofType > int(maxK) {
+			return fmt.Errorf("a proof type must be between 0 and %d", maxK)
+		}
+		p.ProofTypes[i] = uint8(proofType)
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	*batch = p
+	return nil
+}
+
+// batchCBOR is the CBOR representation of a batch proof, it uses the same field names as batchJSON.
+type batchCBOR struct {
+	Version    uint64     `cbor:"version"`
+	Chunks     [][]uint64 `cbor:"chunks"`
+	Proof      [][]byte   `cbor:"proof"`
+	ProofTypes []byte     `cbor:"proofTypes"`
+	ChunkSize  uint64     `cbor:"chunkSize"`
+	Hasher     uint64     `cbor:"hasher"`
+	HashMode   uint64     `cbor:"hashMode"`
+	Layout     uint64     `cbor:"layout"`
+}
+
+// MarshalCBOR encodes the batch proof as a deterministic CBOR map with the same field names as the JSON encoding.
+// Proof hashes and proof types are byte strings, the hasher, the hash mode and the layout are their numeric values.
+func (batch *BatchProof) MarshalCBOR() ([]byte, error) {
+	if err := batch.validateEncoding(); err != nil {
+		return nil, err
+	}
+	v := batchCBOR{
+		Version:    uint64(batchEncodingVersion),
+		Chunks:     batch.Chunks,
+		Proof:      make([][]byte, len(batch.Proof)),
+		ProofTypes: batch.ProofTypes,
+		ChunkSize:  uint64(batch.ChunkSize),
+		Hasher:     uint64(batch.Hasher),
+		HashMode:   uint64(batch.HashMode),
+		Layout:     uint64(batch.Layout),
+	}
+	for i := range batch.Proof {
+		v.Proof[i] = batch.Proof[i][:]
+	}
+	return cborEncMode.Marshal(v)
+}
+
+// UnmarshalCBOR decodes a batch proof encoded by MarshalCBOR. Unknown or duplicate fields, invalid hashes and
+// invalid tree parameters are rejected.
+func (batch *BatchProof) UnmarshalCBOR(data []byte) error {
+	var v batchCBOR
+	if err := cborDecMode.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Version != uint64(batchEncodingVersion) {
+		return fmt.Errorf("unsupported batch proof encoding version %d", v.Version)
+	}
+	if v.ChunkSize > math.MaxUint32 || v.Hasher > math.MaxUint8 || v.HashMode > math.MaxUint8 || v.Layout > math.MaxUint8 {
+		return errors.New("invalid tree parameters")
+	}
+	p := BatchProof{
+		Chunks:     v.Chunks,
+		ProofTypes: v.ProofTypes,
+		ChunkSize:  int(v.ChunkSize),
+		Hasher:     Hasher(v.Hasher),
+		HashMode:   HashMode(v.HashMode),
+		Layout:     Layout(v.Layout),
+	}
+	if len(v.Proof) > 0 {
+		p.Proof = make([][32]byte, len(v.Proof))
+	}
+	for i, h := range v.Proof {
+		if len(h) != len(p.Proof[i]) {
+			return fmt.Errorf("a hash must be %d bytes long, but got %d", len(p.Proof[i]), len(h))
+		}
+		copy(p.Proof[i][:], h)
+	}
+	if err := p.validateEncoding(); err != nil {
+		return err
+	}
+	*batch = p
+	return nil
+}
@@ -0,0 +1,495 @@
+package bloomtree
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCompactMultiProofBinaryRoundTrip(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}}...)
+	tree, err := NewBloomTree(dbf, WithChunkSize(128), WithHasher(BLAKE2b256), WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range [][]byte{{1}, {9}} {
+		multiproof, err := tree.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := multiproof.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded CompactMultiProof
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*multiproof, decoded) {
+			t.Fatalf("expected %+v, but got %+v", *multiproof, decoded)
+		}
+		again, err := decoded.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Fatal("encoding is not deterministic")
+		}
+		verified, err := VerifyCompactMultiProof(elem, []byte(seed), &decoded, tree.Root(), dbf, WithChunkSize(128), WithHasher(BLAKE2b256), WithHashMode(HardenedHashMode))
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatal("failed to verify decoded proof")
+		}
+	}
+}
+
+func TestCompactMultiProofUnmarshalBinaryInvalid(t *testing.T) {
+	dbf := generateDBF(200, "secret seed", [][]byte{{1}, {2}, {3}}...)
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof([]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := multiproof.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(f func(b []byte) []byte) []byte {
+		b := make([]byte, len(data))
+		copy(b, data)
+		return f(b)
+	}
+
+	var tests = []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "truncated", data: data[:len(data)-1]},
+		{name: "trailing bytes", data: append(modify(func(b []byte) []byte { return b }), 0)},
+		{name: "unknown version", data: modify(func(b []byte) []byte { b[0] = 2; return b })},
+		{name: "unknown hash mode", data: modify(func(b []byte) []byte { b[len(b)-1] = 9; return b })},
+		{name: "unknown hasher", data: modify(func(b []byte) []byte { b[len(b)-2] = 200; return b })},
+		{name: "invalid chunk size", data: modify(func(b []byte) []byte { b[len(b)-3] = 65; return b })},
+		{name: "huge chunk size", data: modify(func(b []byte) []byte { b[len(b)-6] = 0x80; return b })},
+		{name: "huge chunk count", data: modify(func(b []byte) []byte { b[2] = 0xff; return b })},
+		{name: "empty chunk", data: []byte{1, 255, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 64, 0, 0}},
+		{name: "oversized chunk", data: []byte{1, 255, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2,
+			0, 0, 0, 0, 0, 0, 0, 64, 0, 0}},
+	}
+
+	for _, test := range tests {
+		var decoded CompactMultiProof
+		if err := decoded.UnmarshalBinary(test.data); err == nil {
+			t.Fatalf("expected error for %s encoding", test.name)
+		}
+	}
+}
+
+func TestCompactMultiProofUnmarshalBinaryMinimal(t *testing.T) {
+	data := []byte{1, 255, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 64, 0, 0}
+	var decoded CompactMultiProof
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	expected := CompactMultiProof{Chunks: [][]uint64{{1}}, ProofType: maxK, ChunkSize: 64}
+	if !reflect.DeepEqual(expected, decoded) {
+		t.Fatalf("expected %+v, but got %+v", expected, decoded)
+	}
+}
+
+func TestCompactMultiProofMarshalBinaryInvalid(t *testing.T) {
+	var tests = []CompactMultiProof{
+		{Chunks: [][]uint64{{1}}, ChunkSize: 100},
+		{Chunks: [][]uint64{{1}}, ChunkSize: 1 << 20},
+		{Chunks: [][]uint64{{1}}, ChunkSize: 64, Hasher: Hasher(200)},
+		{Chunks: nil, ChunkSize: 64},
+		{Chunks: [][]uint64{{1, 2}}, ChunkSize: 64},
+	}
+	for _, multiproof := range tests {
+		if _, err := multiproof.MarshalBinary(); err == nil {
+			t.Fatalf("expected error for proof %+v", multiproof)
+		}
+	}
+}
+
+func TestCompactMultiProofJSONAndCBORRoundTrip(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}}...)
+	tree, err := NewBloomTree(dbf, WithHasher(Keccak256), WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range [][]byte{{1}, {9}} {
+		multiproof, err := tree.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := json.Marshal(multiproof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromJSON CompactMultiProof
+		if err := json.Unmarshal(data, &fromJSON); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*multiproof, fromJSON) {
+			t.Fatalf("expected %+v, but got %+v", *multiproof, fromJSON)
+		}
+
+		data, err = cbor.Marshal(multiproof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromCBOR CompactMultiProof
+		if err := cbor.Unmarshal(data, &fromCBOR); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*multiproof, fromCBOR) {
+			t.Fatalf("expected %+v, but got %+v", *multiproof, fromCBOR)
+		}
+		again, err := fromCBOR.MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Fatal("CBOR encoding is not deterministic")
+		}
+	}
+}
+
+func TestCompactMultiProofJSONFields(t *testing.T) {
+	multiproof := &CompactMultiProof{
+		Chunks:    [][]uint64{{1, 0xff}},
+		Proof:     [][32]byte{{0xab}},
+		ProofType: 1,
+		ChunkSize: 128,
+		Hasher:    SHA256,
+		HashMode:  HardenedHashMode,
+	}
+	data, err := json.Marshal(multiproof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":1,"proofType":1,"chunks":["000000000000000100000000000000ff"],` +
+		`"proof":["ab00000000000000000000000000000000000000000000000000000000000000"],` +
+		`"chunkSize":128,"hasher":"SHA-256","hashMode":"hardened"}`
+	if string(data) != expected {
+		t.Fatalf("expected %s, but got %s", expected, data)
+	}
+}
+
+func TestCompactMultiProofUnmarshalJSONInvalid(t *testing.T) {
+	valid := `"chunks":["0000000000000001"],"proof":["ab00000000000000000000000000000000000000000000000000000000000000"],` +
+		`"chunkSize":64,"hasher":"SHA-256","hashMode":"legacy"`
+	var tests = []struct {
+		name string
+		data string
+	}{
+		{name: "valid", data: `{"version":1,"proofType":255,` + valid + `}`},
+		{name: "unknown version", data: `{"version":2,"proofType":255,` + valid + `}`},
+		{name: "proof type too large", data: `{"version":1,"proofType":256,` + valid + `}`},
+		{name: "negative proof type", data: `{"version":1,"proofType":-1,` + valid + `}`},
+		{name: "unknown field", data: `{"version":1,"proofType":255,"extra":1,` + valid + `}`},
+		{name: "short hash", data: `{"version":1,"proofType":255,"chunks":["0000000000000001"],"proof":["ab"],` +
+			`"chunkSize":64,"hasher":"SHA-256","hashMode":"legacy"}`},
+		{name: "unknown hasher", data: `{"version":1,"proofType":255,"chunks":["0000000000000001"],"proof":[],` +
+			`"chunkSize":64,"hasher":"MD5","hashMode":"legacy"}`},
+		{name: "partial word", data: `{"version":1,"proofType":255,"chunks":["00000001"],"proof":[],` +
+			`"chunkSize":64,"hasher":"SHA-256","hashMode":"legacy"}`},
+		{name: "absence proof with 2 chunks", data: `{"version":1,"proofType":0,"chunks":["0000000000000001","0000000000000001"],` +
+			`"proof":[],"chunkSize":64,"hasher":"SHA-256","hashMode":"legacy"}`},
+	}
+
+	for _, test := range tests {
+		var decoded CompactMultiProof
+		err := json.Unmarshal([]byte(test.data), &decoded)
+		if test.name == "valid" {
+			if err != nil {
+				t.Fatal(err)
+			}
+		} else if err == nil {
+			t.Fatalf("expected error for %s", test.name)
+		}
+	}
+}
+
+func TestCompactMultiProofUnmarshalCBORInvalid(t *testing.T) {
+	valid := proofCBOR{
+		Version:   1,
+		ProofType: 255,
+		Chunks:    [][]uint64{{1}},
+		Proof:     [][]byte{make([]byte, 32)},
+		ChunkSize: 64,
+	}
+	var tests = []struct {
+		name   string
+		modify func(v *proofCBOR)
+	}{
+		{name: "unknown version", modify: func(v *proofCBOR) { v.Version = 2 }},
+		{name: "proof type too large", modify: func(v *proofCBOR) { v.ProofType = 256 }},
+		{name: "short hash", modify: func(v *proofCBOR) { v.Proof[0] = v.Proof[0][:31] }},
+		{name: "unknown hasher", modify: func(v *proofCBOR) { v.Hasher = 300 }},
+		{name: "empty chunk", modify: func(v *proofCBOR) { v.Chunks = [][]uint64{{}} }},
+	}
+
+	for _, test := range tests {
+		v := valid
+		v.Proof = [][]byte{make([]byte, 32)}
+		test.modify(&v)
+		data, err := cbor.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded CompactMultiProof
+		if err := cbor.Unmarshal(data, &decoded); err == nil {
+			t.Fatalf("expected error for %s", test.name)
+		}
+	}
+
+	data, err := cbor.Marshal(map[string]interface{}{"version": 1, "unknown": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded CompactMultiProof
+	if err := cbor.Unmarshal(data, &decoded); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestRootEncoding(t *testing.T) {
+	tree, err := NewBloomTree(generateDBF(200, "secret seed", [][]byte{{1}, {2}}...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.Root()
+
+	data, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"`+root.String()+`"` {
+		t.Fatalf("expected root to encode as a hex string, but got %s", data)
+	}
+	var fromJSON Root
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if fromJSON != root {
+		t.Fatal("root changed in JSON round trip")
+	}
+
+	data, err = cbor.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromCBOR Root
+	if err := cbor.Unmarshal(data, &fromCBOR); err != nil {
+		t.Fatal(err)
+	}
+	if fromCBOR != root {
+		t.Fatal("root changed in CBOR round trip")
+	}
+
+	if err := json.Unmarshal([]byte(`"abcd"`), &fromJSON); err == nil {
+		t.Fatal("expected error for a short root")
+	}
+	short, err := cbor.Marshal(root[:31])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cbor.Unmarshal(short, &fromCBOR); err == nil {
+		t.Fatal("expected error for a short root")
+	}
+}
+
+func TestCompactMultiProofLayoutEncoding(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(300, seed, [][]byte{{1}, {2}, {3}}...)
+	tree, err := NewBloomTree(dbf, WithLayout(BalancedLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof([]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := multiproof.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != layoutEncodingVersion || data[len(data)-1] != byte(BalancedLayout) {
+		t.Fatalf("expected version %d with a trailing layout byte", layoutEncodingVersion)
+	}
+	var decoded CompactMultiProof
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*multiproof, decoded) {
+		t.Fatalf("expected %+v, but got %+v", *multiproof, decoded)
+	}
+	// a version 1 encoding cannot carry a layout, and version 2 must not carry the padded layout
+	invalid := append([]byte{proofEncodingVersion}, data[1:]...)
+	if err := decoded.UnmarshalBinary(invalid); err == nil {
+		t.Fatal("expected error for a layout in a version 1 encoding")
+	}
+	invalid = append([]byte{}, data...)
+	invalid[len(invalid)-1] = byte(PaddedLayout)
+	if err := decoded.UnmarshalBinary(invalid); err == nil {
+		t.Fatal("expected error for the padded layout in a version 2 encoding")
+	}
+	invalid[0] = 3
+	if err := decoded.UnmarshalBinary(invalid); err == nil {
+		t.Fatal("expected error for an unknown version")
+	}
+
+	data, err = json.Marshal(multiproof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"version":2`)) || !bytes.Contains(data, []byte(`"layout":"balanced"`)) {
+		t.Fatalf("expected version 2 and the layout in %s", data)
+	}
+	var fromJSON CompactMultiProof
+	if err := json.Unmarshal(data, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*multiproof, fromJSON) {
+		t.Fatalf("expected %+v, but got %+v", *multiproof, fromJSON)
+	}
+	if err := json.Unmarshal(bytes.Replace(data, []byte(`"version":2`), []byte(`"version":1`), 1), &fromJSON); err == nil {
+		t.Fatal("expected error for a layout in a version 1 JSON encoding")
+	}
+
+	data, err = cbor.Marshal(multiproof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromCBOR CompactMultiProof
+	if err := cbor.Unmarshal(data, &fromCBOR); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*multiproof, fromCBOR) {
+		t.Fatalf("expected %+v, but got %+v", *multiproof, fromCBOR)
+	}
+}
+
+func TestBatchProofEncodingRoundTrip(t *testing.T) {
+	seed := "secret seed"
+	var elements [][]byte
+	for i := 0; i < 50; i++ {
+		elements = append(elements, []byte{byte(i)})
+	}
+	dbf := generateDBF(200, seed, elements...)
+	opts := []Option{WithChunkSize(128), WithHasher(BLAKE2b256), WithHashMode(HardenedHashMode), WithLayout(BalancedLayout)}
+	tree, err := NewBloomTree(dbf, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := [][]byte{{1}, {20}, {60}, {99}}
+	proof, err := tree.GenerateBatchProof(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodings := []struct {
+		name      string
+		marshal   func(*BatchProof) ([]byte, error)
+		unmarshal func([]byte, *BatchProof) error
+	}{
+		{name: "binary", marshal: (*BatchProof).MarshalBinary, unmarshal: func(b []byte, p *BatchProof) error { return p.UnmarshalBinary(b) }},
+		{name: "JSON", marshal: func(p *BatchProof) ([]byte, error) { return json.Marshal(p) }, unmarshal: func(b []byte, p *BatchProof) error { return json.Unmarshal(b, p) }},
+		{name: "CBOR", marshal: func(p *BatchProof) ([]byte, error) { return cbor.Marshal(p) }, unmarshal: func(b []byte, p *BatchProof) error { return cbor.Unmarshal(b, p) }},
+	}
+	for _, encoding := range encodings {
+		data, err := encoding.marshal(proof)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding.name, err)
+		}
+		var decoded BatchProof
+		if err := encoding.unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: %v", encoding.name, err)
+		}
+		if !reflect.DeepEqual(*proof, decoded) {
+			t.Fatalf("%s: expected %+v, but got %+v", encoding.name, *proof, decoded)
+		}
+		again, err := encoding.marshal(&decoded)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding.name, err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Fatalf("%s encoding is not deterministic", encoding.name)
+		}
+		verified, err := VerifyBatchProof(batch, []byte(seed), &decoded, tree.Root(), dbf, opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding.name, err)
+		} else if !verified {
+			t.Fatalf("%s: failed to verify decoded batch proof", encoding.name)
+		}
+	}
+}
+
+func TestBatchProofUnmarshalInvalid(t *testing.T) {
+	dbf := generateDBF(200, "secret seed", [][]byte{{1}, {2}, {3}}...)
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.GenerateBatchProof([][]byte{{1}, {2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := proof.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(f func(b []byte) []byte) []byte {
+		b := make([]byte, len(data))
+		copy(b, data)
+		return f(b)
+	}
+
+	var tests = []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "truncated", data: data[:len(data)-1]},
+		{name: "trailing bytes", data: append(modify(func(b []byte) []byte { return b }), 0)},
+		{name: "unknown version", data: modify(func(b []byte) []byte { b[0] = 2; return b })},
+		{name: "unknown layout", data: modify(func(b []byte) []byte { b[len(b)-1] = 9; return b })},
+		{name: "unknown hash mode", data: modify(func(b []byte) []byte { b[len(b)-2] = 9; return b })},
+		{name: "unknown hasher", data: modify(func(b []byte) []byte { b[len(b)-3] = 200; return b })},
+		{name: "invalid chunk size", data: modify(func(b []byte) []byte { b[len(b)-4] = 65; return b })},
+		{name: "huge chunk count", data: modify(func(b []byte) []byte { b[1] = 0xff; return b })},
+		{name: "no proof types", data: []byte{1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0,
+			0, 0, 0, 64, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		var decoded BatchProof
+		if err := decoded.UnmarshalBinary(test.data); err == nil {
+			t.Fatalf("expected error for %s encoding", test.name)
+		}
+	}
+
+	var decoded BatchProof
+	if err := json.Unmarshal([]byte(`{"version":1,"chunks":["0000000000000001"],"proof":[],"proofTypes":[256],"chunkSize":64,"hasher":"SHA-512/256","hashMode":"legacy","layout":"padded"}`), &decoded); err == nil {
+		t.Fatal("expected error for a proof type that does not fit into a byte")
+	}
+	if err := json.Unmarshal([]byte(`{"version":2}`), &decoded); err == nil {
+		t.Fatal("expected error for an unknown JSON version")
+	}
+	if err := cbor.Unmarshal([]byte{0xa1, 0x67, 'v', 'e', 'r', 's', 'i', 'o', 'n', 0x02}, &decoded); err == nil {
+		t.Fatal("expected error for an unknown CBOR version")
+	}
+}
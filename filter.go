@@ -0,0 +1,203 @@
+package bloomtree
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/willf/bitset"
+)
+
+// IndexScheme selects how the bloom filter maps an element to its indices.
+type IndexScheme uint8
+
+const (
+	// DBFIndexScheme is the index derivation of the DBF package. It is the default.
+	DBFIndexScheme IndexScheme = iota
+	// DoubleHashingIndexScheme is the keyed double hashing of StandardFilter.
+	DoubleHashingIndexScheme
+	// BlockedIndexScheme is the keyed double hashing within one block of BlockedFilter. The block size is the
+	// chunk size of the tree.
+	BlockedIndexScheme
+	maxIndexScheme
+)
+
+// Valid returns whether the index scheme is known.
+func (s IndexScheme) Valid() bool {
+	return s < maxIndexScheme
+}
+
+func (s IndexScheme) String() string {
+	switch s {
+	case DBFIndexScheme:
+		return "dbf"
+	case DoubleHashingIndexScheme:
+		return "double-hashing"
+	case BlockedIndexScheme:
+		return "blocked"
+	default:
+		return fmt.Sprintf("IndexScheme(%d)", uint8(s))
+	}
+}
+
+// IndexSchemer is implemented by bloom filters that do not use the index derivation of the DBF package. Bloom
+// filters without it are assumed to use DBFIndexScheme. The tree and the verifiers reject a filter that reports an
+// unknown scheme, since they could not map elements to the indices it sets.
+type IndexSchemer interface {
+	IndexScheme() IndexScheme
+}
+
+// indexSchemeOf returns the index scheme of a bloom filter.
+func indexSchemeOf(bf BloomFilter) IndexScheme {
+	if s, ok := bf.(IndexSchemer); ok {
+		return s.IndexScheme()
+	}
+	return DBFIndexScheme
+}
+
+// keyedFilter holds the bits and parameters shared by the bloom filters of this package, and implements the
+// methods of BloomFilter that only differ in the index scheme.
+type keyedFilter struct {
+	b      *bitset.BitSet
+	m      uint
+	k      uint
+	seed   []byte
+	scheme IndexScheme
+	// blockSize is the block size of BlockedIndexScheme.
+	blockSize uint
+}
+
+// newKeyedFilter creates an empty bloom filter of m bits and k hash functions with a copy of the seed.
+func newKeyedFilter(m, k uint, seed []byte, scheme IndexScheme) (keyedFilter, error) {
+	if m == 0 {
+		return keyedFilter{}, errors.New("the bloom filter must have at least 1 bit")
+	}
+	if err := validK(k); err != nil {
+		return keyedFilter{}, err
+	}
+	s := make([]byte, len(seed))
+	copy(s, seed)
+	return keyedFilter{b: bitset.New(m), m: m, k: k, seed: s, scheme: scheme}, nil
+}
+
+// validK checks that k fits the proof type of a presence proof.
+func validK(k uint) error {
+	if k == 0 || k >= uint(maxK) {
+		return fmt.Errorf("parameter k of the bloom filter must be between 1 and %d", maxK-1)
+	}
+	return nil
+}
+
+// Add inserts the element into the bloom filter.
+func (f *keyedFilter) Add(element []byte) {
+	for _, index := range f.GetElementIndices(element) {
+		f.b.Set(index)
+	}
+}
+
+// Test returns whether the element may be in the bloom filter.
+func (f *keyedFilter) Test(element []byte) bool {
+	_, present := f.Proof(element)
+	return present
+}
+
+// Proof returns the indices of the element and true if the element is in the bloom filter. Otherwise, it returns
+// the first index of the element that is zero and false.
+func (f *keyedFilter) Proof(element []byte) ([]uint64, bool) {
+	indices := f.GetElementIndices(element)
+	ret := make([]uint64, 0, len(indices))
+	for _, index := range indices {
+		if !f.b.Test(index) {
+			return []uint64{uint64(index)}, false
+		}
+		ret = append(ret, uint64(index))
+	}
+	return ret, true
+}
+
+// BitArray returns the bits of the bloom filter.
+func (f *keyedFilter) BitArray() *bitset.BitSet {
+	return f.b
+}
+
+// setBitSet replaces the bits of the bloom filter. It is not exported on keyedFilter itself, since replacing the
+// bits of a CountingFilter would leave its counters behind.
+func (f *keyedFilter) setBitSet(b *bitset.BitSet) error {
+	if b == nil || b.Len() != f.m {
+		return fmt.Errorf("the bit set must have the length %d of the bloom filter", f.m)
+	}
+	f.b = b
+	return nil
+}
+
+// MapElementToBF returns the indices the element would have in a bloom filter of the same size with another seed.
+func (f *keyedFilter) MapElementToBF(element, seed []byte) []uint {
+	return mapElement(element, seed, Params{M: f.m, K: f.k, ChunkSize: int(f.blockSize), IndexScheme: f.scheme})
+}
+
+// NumOfHashes returns the number of hash functions k.
+func (f *keyedFilter) NumOfHashes() uint {
+	return f.k
+}
+
+// GetElementIndices returns the indices of the element in the bloom filter.
+func (f *keyedFilter) GetElementIndices(element []byte) []uint {
+	return f.MapElementToBF(element, f.seed)
+}
+
+// IndexScheme returns the index scheme of the bloom filter.
+func (f *keyedFilter) IndexScheme() IndexScheme {
+	return f.scheme
+}
+
+// StandardFilter is a plain bloom filter of m bits and k hash functions. The indices of an element are derived
+// with keyed double hashing:
+//
+//	d  = SHA512/256(len(seed) as 8 bytes little endian || seed || element)
+//	h1 = the first 8 bytes of d, little endian
+//	h2 = the next 8 bytes of d, little endian, with the lowest bit set
+//	index i = (h1 + i*h2) mod 2^64 mod m, for i = 0, ..., k-1
+type StandardFilter struct {
+	keyedFilter
+}
+
+// NewStandardFilter creates an empty bloom filter of m bits and k hash functions, keyed with the seed.
+func NewStandardFilter(m, k uint, seed []byte) (*StandardFilter, error) {
+	f, err := newKeyedFilter(m, k, seed, DoubleHashingIndexScheme)
+	if err != nil {
+		return nil, err
+	}
+	return &StandardFilter{f}, nil
+}
+
+// SetBitSet replaces the bits of the bloom filter. It returns an error if the bit set does not have the length
+// of the bloom filter.
+func (f *StandardFilter) SetBitSet(b *bitset.BitSet) error {
+	return f.setBitSet(b)
+}
+
+// EstimateParameters returns the number of bits m and hash functions k of a bloom filter for n elements
+// with a false positive rate of fpr.
+func EstimateParameters(n uint, fpr float64) (m uint, k uint) {
+	m = uint(math.Ceil(-1 * float64(n) * math.Log(fpr) / math.Pow(math.Log(2), 2)))
+	k = uint(math.Ceil(math.Log(2) * float64(m) / float64(n)))
+	return
+}
+
+// doubleHashing returns the indices of an element for the given seed using keyed double hashing.
+func doubleHashing(element, seed []byte, m, k uint) []uint {
+	data := make([]byte, 8, 8+len(seed)+len(element))
+	binary.LittleEndian.PutUint64(data, uint64(len(seed)))
+	data = append(data, seed...)
+	data = append(data, element...)
+	d := sha512.Sum512_256(data)
+	h1 := binary.LittleEndian.Uint64(d[0:8])
+	h2 := binary.LittleEndian.Uint64(d[8:16]) | 1
+	indices := make([]uint, k)
+	for i := uint64(0); i < uint64(k); i++ {
+		indices[i] = uint((h1 + i*h2) % uint64(m))
+	}
+	return indices
+}
@@ -0,0 +1,200 @@
+package bloomtree
+
+import (
+	"testing"
+
+	"github.com/willf/bitset"
+)
+
+func TestStandardFilterIndices(t *testing.T) {
+	var tests = []struct {
+		element  []byte
+		seed     []byte
+		m        uint
+		k        uint
+		expected []uint
+	}{
+		{element: []byte("Foo"), seed: []byte("secret seed"), m: 1000, k: 5, expected: []uint{904, 773, 26, 895, 148}},
+		{element: []byte{}, seed: []byte{}, m: 64, k: 3, expected: []uint{5, 10, 15}},
+	}
+
+	for _, test := range tests {
+		f, err := NewStandardFilter(test.m, test.k, test.seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indices := f.GetElementIndices(test.element)
+		if len(indices) != len(test.expected) {
+			t.Fatalf("expected %d indices, but got %d", len(test.expected), len(indices))
+		}
+		for i := range test.expected {
+			if indices[i] != test.expected[i] {
+				t.Fatalf("expected indices %v, but got %v", test.expected, indices)
+			}
+		}
+	}
+}
+
+func TestStandardFilter(t *testing.T) {
+	m, k := EstimateParameters(200, 0.2)
+	f, err := NewStandardFilter(m, k, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		f.Add([]byte{byte(i)})
+	}
+	for i := 0; i < 50; i++ {
+		if !f.Test([]byte{byte(i)}) {
+			t.Fatalf("expected element %d to be in the bloom filter", i)
+		}
+	}
+	if _, err := NewStandardFilter(0, k, nil); err == nil {
+		t.Fatal("expected error for a bloom filter without bits")
+	}
+	if _, err := NewStandardFilter(m, 0, nil); err == nil {
+		t.Fatal("expected error for a bloom filter without hash functions")
+	}
+	if _, err := NewStandardFilter(m, uint(maxK), nil); err == nil {
+		t.Fatal("expected error for a bloom filter with too many hash functions")
+	}
+}
+
+func TestStandardFilterProofs(t *testing.T) {
+	seed := []byte("secret seed")
+	f, err := NewStandardFilter(1000, 4, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i += 2 {
+		f.Add([]byte{byte(i)})
+	}
+	tree, err := NewBloomTree(f, WithChunkSize(128))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Params().IndexScheme != DoubleHashingIndexScheme {
+		t.Fatalf("expected index scheme %v, but got %v", DoubleHashingIndexScheme, tree.Params().IndexScheme)
+	}
+	verifier, err := NewVerifier(tree.Root(), tree.Params(), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbfParams := tree.Params()
+	dbfParams.IndexScheme = DBFIndexScheme
+	dbfVerifier, err := NewVerifier(tree.Root(), dbfParams, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		elem := []byte{byte(i)}
+		proof, err := tree.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if CheckProofType(proof.ProofType) != f.Test(elem) {
+			t.Fatalf("expected proof type of element %d to match the bloom filter", i)
+		}
+		verified, err := VerifyCompactMultiProof(elem, seed, proof, tree.Root(), f, WithChunkSize(128))
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof of element %d", i)
+		}
+		verified, err = verifier.Verify(elem, proof)
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof of element %d with the verifier", i)
+		}
+		// An absence proof may hold a zero bit at one of the DBF indices by chance, so only presence proofs must
+		// be rejected.
+		if !f.Test(elem) {
+			continue
+		}
+		if verified, err := dbfVerifier.Verify(elem, proof); err == nil && verified {
+			t.Fatalf("expected proof of element %d to be rejected with the DBF index scheme", i)
+		}
+	}
+
+	invalid := tree.Params()
+	invalid.IndexScheme = maxIndexScheme
+	if _, err := NewVerifier(tree.Root(), invalid, seed); err == nil {
+		t.Fatal("expected error for an unknown index scheme")
+	}
+}
+
+// unknownSchemeFilter is a bloom filter that reports an index scheme the tree does not know.
+type unknownSchemeFilter struct {
+	*StandardFilter
+}
+
+func (f unknownSchemeFilter) IndexScheme() IndexScheme {
+	return IndexScheme(9)
+}
+
+func TestUnknownIndexScheme(t *testing.T) {
+	sf, err := NewStandardFilter(1000, 4, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Add([]byte("Foo"))
+	tree, err := NewBloomTree(sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof([]byte("Foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf := unknownSchemeFilter{sf}
+	if _, err := NewBloomTree(bf); err == nil {
+		t.Fatal("expected error for a bloom filter with an unknown index scheme")
+	}
+	if _, err := VerifyCompactMultiProof([]byte("Foo"), []byte("secret seed"), multiproof, tree.Root(), bf); err == nil {
+		t.Fatal("expected verification to fail for a bloom filter with an unknown index scheme")
+	}
+}
+
+func TestSetBitSet(t *testing.T) {
+	sf, err := NewStandardFilter(1000, 4, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf, err := NewBlockedFilter(1024, 4, 256, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name   string
+		filter interface {
+			BloomFilter
+			SetBitSet(*bitset.BitSet) error
+		}
+		m uint
+	}{
+		{name: "standard", filter: sf, m: 1000},
+		{name: "blocked", filter: bf, m: 1024},
+	}
+	for _, test := range tests {
+		for _, length := range []uint{0, test.m - 1, test.m + 1} {
+			if err := test.filter.SetBitSet(bitset.New(length)); err == nil {
+				t.Fatalf("%s: expected error for a bit set of %d bits", test.name, length)
+			}
+		}
+		if err := test.filter.SetBitSet(nil); err == nil {
+			t.Fatalf("%s: expected error for a nil bit set", test.name)
+		}
+		bits := bitset.New(test.m).Set(3)
+		if err := test.filter.SetBitSet(bits); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.filter.BitArray() != bits {
+			t.Fatalf("%s: the bits were not replaced", test.name)
+		}
+	}
+	if _, ok := interface{}(&CountingFilter{}).(checkedBitSetter); ok {
+		t.Fatal("replacing the bits of a counting filter would leave its counters behind")
+	}
+}
@@ -1,9 +1,19 @@
 module github.com/labbloom/bloom-tree
 
-go 1.13
+go 1.20
 
 require (
-	github.com/kr/pretty v0.2.0 // indirect
+	github.com/fxamacker/cbor/v2 v2.5.0
 	github.com/labbloom/DBF v0.0.0-20200120152626-4d4fd29ad009
 	github.com/willf/bitset v1.1.10
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.31.0
+	lukechampine.com/blake3 v1.2.1
+)
+
+require (
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/kr/pretty v0.2.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
@@ -1,22 +1,104 @@
 package bloomtree
 
 import (
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/binary"
-	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+	"lukechampine.com/blake3"
+)
+
+// Hasher identifies the hash function used for the leaves and internal nodes of a bloom tree.
+// The hasher of a tree is recorded in every proof it generates.
+type Hasher uint8
+
+const (
+	// SHA512_256 is SHA-512/256, the default hasher.
+	SHA512_256 Hasher = iota
+	// SHA256 is SHA-256.
+	SHA256
+	// BLAKE2b256 is BLAKE2b with a 256 bit output.
+	BLAKE2b256
+	// Keccak256 is the original Keccak-256, as used by Ethereum.
+	Keccak256
+	// BLAKE3 is BLAKE3 with a 256 bit output.
+	BLAKE3
+	maxHasher
 )
 
-var chunkSize = 64
+// Valid returns whether h is one of the built-in hashers.
+func (h Hasher) Valid() bool {
+	return h < maxHasher
+}
+
+func (h Hasher) String() string {
+	switch h {
+	case SHA512_256:
+		return "SHA-512/256"
+	case SHA256:
+		return "SHA-256"
+	case BLAKE2b256:
+		return "BLAKE2b-256"
+	case Keccak256:
+		return "Keccak-256"
+	case BLAKE3:
+		return "BLAKE3"
+	}
+	return fmt.Sprintf("Hasher(%d)", uint8(h))
+}
+
+// MarshalText encodes the hasher as its name.
+func (h Hasher) MarshalText() ([]byte, error) {
+	if !h.Valid() {
+		return nil, fmt.Errorf("unknown hasher %v", h)
+	}
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText decodes a hasher from its name.
+func (h *Hasher) UnmarshalText(text []byte) error {
+	for v := SHA512_256; v < maxHasher; v++ {
+		if v.String() == string(text) {
+			*h = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown hasher %q", text)
+}
+
+// Sum returns the 256 bit hash of data. It panics if h is not a valid hasher.
+func (h Hasher) Sum(data []byte) [32]byte {
+	switch h {
+	case SHA512_256:
+		return sha512.Sum512_256(data)
+	case SHA256:
+		return sha256.Sum256(data)
+	case BLAKE2b256:
+		return blake2b.Sum256(data)
+	case Keccak256:
+		var sum [32]byte
+		k := sha3.NewLegacyKeccak256()
+		k.Write(data)
+		k.Sum(sum[:0])
+		return sum
+	case BLAKE3:
+		return blake3.Sum256(data)
+	}
+	panic(fmt.Sprintf("bloomtree: unknown hasher %d", uint8(h)))
+}
 
 // Hash returns a 256 bit hash
-func hashChild(elem1, elem2 [32]byte) [32]byte {
+func hashChild(h Hasher, elem1, elem2 [32]byte) [32]byte {
 	var elem []byte
 	elem = append(elem, elem1[:]...)
 	elem = append(elem, elem2[:]...)
-	return sha512.Sum512_256(elem)
+	return h.Sum(elem)
 }
 
-func hashLeaf(index uint64, elements ...uint64) [sha512.Size256]byte {
+func hashLeaf(h Hasher, chunkSize int, index uint64, elements ...uint64) [32]byte {
 	var elem []byte
 
 	a := make([]byte, chunkSize)
@@ -29,13 +111,117 @@ func hashLeaf(index uint64, elements ...uint64) [sha512.Size256]byte {
 		elem = append(elem, b...)
 	}
 
-	return sha512.Sum512_256(elem)
+	return h.Sum(elem)
+}
+
+// HashMode selects how leaves and internal nodes are encoded before hashing.
+type HashMode uint8
+
+const (
+	// LegacyHashMode hashes the padded leaf index followed by the padded chunk words, and the concatenation
+	// of two children for internal nodes. It is the default and keeps existing roots valid.
+	LegacyHashMode HashMode = iota
+	// HardenedHashMode prefixes leaves with 0x00 and internal nodes with 0x01 (as in RFC 6962), and binds
+	// every leaf to the number of leaves of the tree, so internal nodes cannot be passed off as leaves and
+	// proofs cannot be replayed against a tree of a different size.
+	HardenedHashMode
+	maxHashMode
+)
+
+const (
+	leafPrefix = byte(0x00)
+	nodePrefix = byte(0x01)
+)
+
+// Valid returns whether m is a known hash mode.
+func (m HashMode) Valid() bool {
+	return m < maxHashMode
+}
+
+func (m HashMode) String() string {
+	switch m {
+	case LegacyHashMode:
+		return "legacy"
+	case HardenedHashMode:
+		return "hardened"
+	}
+	return fmt.Sprintf("HashMode(%d)", uint8(m))
+}
+
+// MarshalText encodes the hash mode as its name.
+func (m HashMode) MarshalText() ([]byte, error) {
+	if !m.Valid() {
+		return nil, fmt.Errorf("unknown hash mode %v", m)
+	}
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText decodes a hash mode from its name.
+func (m *HashMode) UnmarshalText(text []byte) error {
+	for v := LegacyHashMode; v < maxHashMode; v++ {
+		if v.String() == string(text) {
+			*m = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown hash mode %q", text)
+}
+
+func hashLeafHardened(h Hasher, leafCount, index uint64, elements ...uint64) [32]byte {
+	elem := make([]byte, 17+8*len(elements))
+	elem[0] = leafPrefix
+	binary.LittleEndian.PutUint64(elem[1:], leafCount)
+	binary.LittleEndian.PutUint64(elem[9:], index)
+	for i, e := range elements {
+		binary.LittleEndian.PutUint64(elem[17+8*i:], e)
+	}
+	return h.Sum(elem)
+}
+
+func hashChildHardened(h Hasher, elem1, elem2 [32]byte) [32]byte {
+	elem := make([]byte, 0, 65)
+	elem = append(elem, nodePrefix)
+	elem = append(elem, elem1[:]...)
+	elem = append(elem, elem2[:]...)
+	return h.Sum(elem)
+}
+
+// treeHasher hashes the leaves and internal nodes of a bloom tree with the given parameters.
+type treeHasher struct {
+	hasher    Hasher
+	mode      HashMode
+	chunkSize int
+	leafCount uint64
+}
+
+func newTreeHasher(p Params) treeHasher {
+	return treeHasher{
+		hasher:    p.Hasher,
+		mode:      p.HashMode,
+		chunkSize: p.ChunkSize,
+		leafCount: uint64(numLeafs(numWords(p.M), p.ChunkSize)),
+	}
+}
+
+func (th treeHasher) leaf(index uint64, elements ...uint64) [32]byte {
+	if th.mode == HardenedHashMode {
+		return hashLeafHardened(th.hasher, th.leafCount, index, elements...)
+	}
+	return hashLeaf(th.hasher, th.chunkSize, index, elements...)
+}
+
+// padding returns the hash of the filler leaf at the given index, used when the number of chunks is not
+// a power of two.
+func (th treeHasher) padding(index uint64) [32]byte {
+	if th.mode == HardenedHashMode {
+		return hashLeafHardened(th.hasher, th.leafCount, index)
+	}
+	return hashLeaf(th.hasher, th.chunkSize, uint64(0), index)
 }
 
-func SetChunkSize(v int) error {
-	if v % 64 != 0 {
-		return errors.New("The chunk size must be divisible by 64")
+func (th treeHasher) child(elem1, elem2 [32]byte) [32]byte {
+	if th.mode == HardenedHashMode {
+		return hashChildHardened(th.hasher, elem1, elem2)
 	}
-	chunkSize = v
-	return nil
+	return hashChild(th.hasher, elem1, elem2)
 }
@@ -2,6 +2,7 @@ package bloomtree
 
 import (
 	"crypto/sha512"
+	"encoding/hex"
 	"testing"
 )
 
@@ -20,7 +21,7 @@ func TestHashLeaf(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		output := hashLeaf(test.element, test.index)
+		output := hashLeaf(SHA512_256, 64, test.element, test.index)
 		if output != test.output {
 			t.Fatalf("test failed at hashing element %d and index %d", test.element, test.index)
 		}
@@ -34,23 +35,82 @@ func TestHashChild(t *testing.T) {
 		output   [sha512.Size256]byte
 	}{
 		{
-			element1: hashLeaf(0, 1),
-			element2: hashLeaf(1, 2),
+			element1: hashLeaf(SHA512_256, 64, 0, 1),
+			element2: hashLeaf(SHA512_256, 64, 1, 2),
 			output: [sha512.Size256]byte{202, 116, 135, 95, 85, 135, 228, 38, 153, 127, 237, 234, 194, 152, 113, 112,
 				70, 226, 250, 42, 106, 63, 161, 138, 85, 110, 34, 240, 186, 151, 198, 108},
 		},
 		{
-			element1: hashLeaf(10, 11),
-			element2: hashLeaf(11, 12),
+			element1: hashLeaf(SHA512_256, 64, 10, 11),
+			element2: hashLeaf(SHA512_256, 64, 11, 12),
 			output: [sha512.Size256]byte{105, 250, 104, 250, 231, 6, 222, 161, 109, 46, 208, 106, 94, 20, 246, 171, 169,
 				116, 12, 124, 101, 111, 87, 91, 173, 114, 53, 89, 156, 86, 109, 190},
 		},
 	}
 
 	for _, test := range tests {
-		output := hashChild(test.element1, test.element2)
+		output := hashChild(SHA512_256, test.element1, test.element2)
 		if output != test.output {
 			t.Fatal("test failed at hashing child")
 		}
 	}
 }
+
+func TestHasherSum(t *testing.T) {
+	var tests = []struct {
+		hasher Hasher
+		output string
+	}{
+		{hasher: SHA512_256, output: "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23"},
+		{hasher: SHA256, output: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{hasher: BLAKE2b256, output: "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{hasher: Keccak256, output: "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{hasher: BLAKE3, output: "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85"},
+	}
+
+	for _, test := range tests {
+		output := test.hasher.Sum([]byte("abc"))
+		if hex.EncodeToString(output[:]) != test.output {
+			t.Fatalf("test failed at hashing with %v", test.hasher)
+		}
+	}
+}
+
+func TestHasherValid(t *testing.T) {
+	for h := SHA512_256; h <= BLAKE3; h++ {
+		if !h.Valid() {
+			t.Fatalf("expected %v to be valid", h)
+		}
+	}
+	if Hasher(200).Valid() {
+		t.Fatal("expected unknown hasher to be invalid")
+	}
+}
+
+func TestHashHardened(t *testing.T) {
+	leaf := hashLeafHardened(SHA256, 4, 1, 7)
+	if leaf == hashLeaf(SHA256, 64, 1, 7) {
+		t.Fatal("hardened leaf must differ from legacy leaf")
+	}
+	// the leaf is bound to the number of leaves of the tree
+	if leaf == hashLeafHardened(SHA256, 5, 1, 7) {
+		t.Fatal("hardened leaf must depend on the number of leaves")
+	}
+	// a node and a leaf over the same bytes must not collide
+	var left, right [32]byte
+	left[0], right[0] = 1, 2
+	node := hashChildHardened(SHA256, left, right)
+	if node == hashChild(SHA256, left, right) {
+		t.Fatal("hardened node must differ from legacy node")
+	}
+	var raw []byte
+	raw = append(raw, nodePrefix)
+	raw = append(raw, left[:]...)
+	raw = append(raw, right[:]...)
+	if node != SHA256.Sum(raw) {
+		t.Fatal("hardened node must be the hash of the node prefix and its children")
+	}
+	if raw[0] == leafPrefix {
+		t.Fatal("node and leaf prefixes must differ")
+	}
+}
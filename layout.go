@@ -0,0 +1,96 @@
+package bloomtree
+
+import (
+	"fmt"
+)
+
+// Layout selects how the leaves of a bloom tree are arranged when their number is not a power of two.
+type Layout uint8
+
+const (
+	// PaddedLayout rounds the number of leaves up to the next power of two and fills the gap with padding
+	// leaves. It is the default and keeps existing roots valid.
+	PaddedLayout Layout = iota
+	// BalancedLayout builds a left-balanced tree over the exact number of leaves, as in RFC 6962. A node without
+	// a sibling is moved up a level unchanged, so there are no padding leaves and proofs carry no padding hashes.
+	BalancedLayout
+	maxLayout
+)
+
+// Valid returns whether the layout is known.
+func (l Layout) Valid() bool {
+	return l < maxLayout
+}
+
+func (l Layout) String() string {
+	switch l {
+	case PaddedLayout:
+		return "padded"
+	case BalancedLayout:
+		return "balanced"
+	default:
+		return fmt.Sprintf("Layout(%d)", uint8(l))
+	}
+}
+
+// MarshalText encodes the layout as its name.
+func (l Layout) MarshalText() ([]byte, error) {
+	if !l.Valid() {
+		return nil, fmt.Errorf("unknown layout %v", l)
+	}
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a layout from its name.
+func (l *Layout) UnmarshalText(text []byte) error {
+	for v := PaddedLayout; v < maxLayout; v++ {
+		if v.String() == string(text) {
+			*l = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown layout %q", text)
+}
+
+// treeShape describes the levels of a bloom tree. The nodes of all levels are stored in one slice, starting
+// with the leaves and ending with the root.
+type treeShape struct {
+	// sizes is the number of nodes per level, from the leaves to the root.
+	sizes []int
+	// offsets is the position of the first node of every level in the node slice.
+	offsets []int
+}
+
+// newTreeShape returns the shape of a tree with the given number of chunks.
+func newTreeShape(leafCount int, layout Layout) treeShape {
+	n := leafCount
+	if layout == PaddedLayout {
+		n = 1
+		for n < leafCount {
+			n *= 2
+		}
+	}
+	s := treeShape{sizes: []int{n}, offsets: []int{0}}
+	for n > 1 {
+		s.offsets = append(s.offsets, s.offsets[len(s.offsets)-1]+n)
+		n = (n + 1) / 2
+		s.sizes = append(s.sizes, n)
+	}
+	return s
+}
+
+// len returns the number of nodes of the tree.
+func (s treeShape) len() int {
+	last := len(s.sizes) - 1
+	return s.offsets[last] + s.sizes[last]
+}
+
+// height returns the number of levels above the leaves.
+func (s treeShape) height() int {
+	return len(s.sizes) - 1
+}
+
+// index returns the position of a node in the node slice.
+func (s treeShape) index(level int, pos uint64) int {
+	return s.offsets[level] + int(pos)
+}
@@ -0,0 +1,172 @@
+package bloomtree
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeShape(t *testing.T) {
+	var tests = []struct {
+		leafCount int
+		layout    Layout
+		sizes     []int
+		len       int
+	}{
+		{leafCount: 1, layout: PaddedLayout, sizes: []int{1}, len: 1},
+		{leafCount: 1, layout: BalancedLayout, sizes: []int{1}, len: 1},
+		{leafCount: 5, layout: PaddedLayout, sizes: []int{8, 4, 2, 1}, len: 15},
+		{leafCount: 5, layout: BalancedLayout, sizes: []int{5, 3, 2, 1}, len: 11},
+		{leafCount: 8, layout: BalancedLayout, sizes: []int{8, 4, 2, 1}, len: 15},
+	}
+
+	for _, test := range tests {
+		shape := newTreeShape(test.leafCount, test.layout)
+		if !reflect.DeepEqual(shape.sizes, test.sizes) {
+			t.Fatalf("expected level sizes %v for %d leaves, but got %v", test.sizes, test.leafCount, shape.sizes)
+		}
+		if shape.len() != test.len {
+			t.Fatalf("expected %d nodes for %d leaves, but got %d", test.len, test.leafCount, shape.len())
+		}
+	}
+}
+
+// rfc6962Root computes the root of a left-balanced tree recursively, splitting at the largest power of two
+// smaller than the number of leaves.
+func rfc6962Root(th treeHasher, leafs [][32]byte) [32]byte {
+	if len(leafs) == 1 {
+		return leafs[0]
+	}
+	k := 1
+	for 2*k < len(leafs) {
+		k *= 2
+	}
+	return th.child(rfc6962Root(th, leafs[:k]), rfc6962Root(th, leafs[k:]))
+}
+
+func TestBalancedLayoutRoot(t *testing.T) {
+	seed := "secret seed"
+	for _, n := range []uint{30, 200, 700} {
+		dbf := generateDBF(n, seed, []byte{1}, []byte{2}, []byte{3})
+		for _, mode := range []HashMode{LegacyHashMode, HardenedHashMode} {
+			tree, err := NewBloomTree(dbf, WithLayout(BalancedLayout), WithHashMode(mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bfAsInt := dbf.BitArray().Bytes()
+			leafs := make([][32]byte, numLeafs(len(bfAsInt), defaultChunkSize))
+			for i := range leafs {
+				leafs[i] = tree.treeHasher().leaf(uint64(i), chunkWords(tree, len(bfAsInt), uint64(i), defaultChunkSize)...)
+			}
+			if n := len(nodesOf(tree)); n != newTreeShape(len(leafs), BalancedLayout).len() {
+				t.Fatalf("expected no padding leaves, but got %d nodes for %d leaves", n, len(leafs))
+			}
+			expected := rfc6962Root(tree.treeHasher(), leafs)
+			if tree.Root() != Root(expected) {
+				t.Fatalf("expected root %s for %d leaves, but got %s", Root(expected), len(leafs), tree.Root())
+			}
+		}
+	}
+
+	// without padding, both layouts agree
+	dbf := generateDBF(400, seed, []byte{1})
+	padded, err := NewBloomTree(dbf, WithChunkSize(384))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balanced, err := NewBloomTree(dbf, WithChunkSize(384), WithLayout(BalancedLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(nodesOf(padded)); n != 7 {
+		t.Fatalf("expected 4 leaves, but got %d nodes", n)
+	}
+	if padded.Root() != balanced.Root() {
+		t.Fatalf("expected root %s for a power of two leaves, but got %s", padded.Root(), balanced.Root())
+	}
+}
+
+func TestBalancedLayoutProofs(t *testing.T) {
+	seed := "secret seed"
+	var elements [][]byte
+	for i := 0; i < 100; i++ {
+		elements = append(elements, []byte{byte(i)})
+	}
+	dbf := generateDBF(300, seed, elements...)
+	tree, err := NewBloomTree(dbf, WithLayout(BalancedLayout), WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Params().Layout != BalancedLayout {
+		t.Fatalf("expected layout %v, but got %v", BalancedLayout, tree.Params().Layout)
+	}
+	verifier, err := NewVerifier(tree.Root(), tree.Params(), []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paddedParams := tree.Params()
+	paddedParams.Layout = PaddedLayout
+	paddedVerifier, err := NewVerifier(tree.Root(), paddedParams, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 200; i++ {
+		elem := []byte{byte(i)}
+		proof, err := tree.GenerateCompactMultiProof(elem)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Layout != BalancedLayout {
+			t.Fatalf("expected proof layout %v, but got %v", BalancedLayout, proof.Layout)
+		}
+		verified, err := verifier.Verify(elem, proof)
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof of element %d", i)
+		}
+		verified, err = VerifyCompactMultiProof(elem, []byte(seed), proof, tree.Root(), dbf, WithLayout(BalancedLayout), WithHashMode(HardenedHashMode))
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof of element %d without a verifier", i)
+		}
+		if verified, err := paddedVerifier.Verify(elem, proof); err == nil && verified {
+			t.Fatalf("expected proof of element %d to be rejected for the padded layout", i)
+		}
+	}
+
+	batch, err := tree.GenerateBatchProof(elements[:20])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := verifier.VerifyBatch(elements[:20], batch)
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified {
+		t.Fatal("failed to verify batch proof")
+	}
+}
+
+func TestBalancedLayoutUpdate(t *testing.T) {
+	dbf := generateDBF(300, "secret seed", []byte{1})
+	tree, err := NewBloomTree(dbf, WithLayout(BalancedLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i < 50; i++ {
+		if err := tree.Add([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	full, err := NewBloomTree(dbf, WithLayout(BalancedLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Root() != full.Root() {
+		t.Fatalf("expected root %s after adding elements, but got %s", full.Root(), tree.Root())
+	}
+	if _, err := NewBloomTree(dbf, WithLayout(maxLayout)); err == nil {
+		t.Fatal("expected error for an unknown layout")
+	}
+}
@@ -0,0 +1,202 @@
+package bloomtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"unsafe"
+
+	"github.com/willf/bitset"
+)
+
+// mmapMagic starts every file of an MmapStore.
+var mmapMagic = [4]byte{'B', 'L', 'M', 'M'}
+
+// mmapVersion is the version of the file layout of an MmapStore.
+const mmapVersion = byte(2)
+
+// mmapHeaderSize is the size of the file header, a multiple of 8 so the words that follow it are aligned.
+const mmapHeaderSize = 24
+
+// MmapStore is a node store that keeps the bits of the bloom filter, the tree's copy of them and the nodes of a
+// tree in a memory-mapped file instead of the Go heap. Proofs only read the pages of the words and nodes they
+// need, and the operating system can page out the rest. The bloom filter must have a SetBitSet method: when the
+// tree is built, its bits are copied into the file and replaced by a bit set backed by the mapping. Close copies
+// them back to the heap, so the bloom filter stays usable. The file is scratch space for a single tree and is
+// never read back; use a BoltStore or WriteTo to keep a tree across restarts. The file starts with a header:
+//
+//	magic       4 bytes "BLMM"
+//	version     1 byte
+//	reserved    3 bytes
+//	word count  8 bytes, little endian
+//	node count  8 bytes, little endian
+//
+// followed by the words of the tree, then the words of the bloom filter, 8 bytes each in the byte order of the
+// machine, and the nodes, 32 bytes each, ordered by level from the leaves to the root and by position within a
+// level.
+type MmapStore struct {
+	file  *os.File
+	data  []byte
+	shape treeShape
+	words []uint64
+	nodes [][32]byte
+	// bits backs the bit set of bf.
+	bits []uint64
+	bf   BloomFilter
+}
+
+// NewMmapStore creates the file of an MmapStore at path, truncating an existing file, so a store cannot be
+// reopened. The file is mapped when a tree is built with the store, and stays mapped until Close is called.
+func NewMmapStore(path string) (*MmapStore, error) {
+	if !mmapSupported {
+		return nil, errors.New("memory-mapped storage is not supported on this platform")
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &MmapStore{file: file}, nil
+}
+
+func (s *MmapStore) allocate(shape treeShape, numWords int) ([]uint64, [][32]byte, error) {
+	if s.file == nil {
+		return nil, nil, errors.New("the memory-mapped store is closed")
+	}
+	if s.data != nil {
+		return nil, nil, errors.New("the memory-mapped store already holds a tree")
+	}
+	size := mmapHeaderSize + 2*8*numWords + 32*shape.len()
+	if err := s.file.Truncate(int64(size)); err != nil {
+		return nil, nil, err
+	}
+	data, err := mapFile(s.file, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(data, mmapMagic[:])
+	data[4] = mmapVersion
+	binary.LittleEndian.PutUint64(data[8:], uint64(numWords))
+	binary.LittleEndian.PutUint64(data[16:], uint64(shape.len()))
+	wordsEnd := mmapHeaderSize + 8*numWords
+	bitsEnd := wordsEnd + 8*numWords
+	s.data = data
+	s.shape = shape
+	s.words = bytesToWords(data[mmapHeaderSize:wordsEnd])
+	s.bits = bytesToWords(data[wordsEnd:bitsEnd])
+	s.nodes = bytesToNodes(data[bitsEnd:])
+	return s.words, s.nodes, nil
+}
+
+// holdBits copies the bits of the bloom filter into the file and replaces them with a bit set backed by the
+// mapping.
+func (s *MmapStore) holdBits(b BloomFilter) error {
+	if !canSetBitSet(b) {
+		return errors.New("the memory-mapped store requires a bloom filter whose bits can be replaced")
+	}
+	src := b.BitArray()
+	if len(src.Bytes()) != len(s.bits) {
+		return errors.New("the number of words does not match the bloom filter")
+	}
+	copy(s.bits, src.Bytes())
+	bits, err := wordsToBitSet(s.bits, src.Len())
+	if err != nil {
+		return err
+	}
+	if err := setBitSet(b, bits); err != nil {
+		return err
+	}
+	s.bf = b
+	return nil
+}
+
+// holdsBits returns whether the bits of the bloom filter are still backed by the mapping.
+func (s *MmapStore) holdsBits() bool {
+	if s.bf == nil || len(s.bits) == 0 {
+		return false
+	}
+	words := s.bf.BitArray().Bytes()
+	return len(words) > 0 && &words[0] == &s.bits[0]
+}
+
+// Get returns the node at the given level and index.
+func (s *MmapStore) Get(level int, index uint64) ([32]byte, error) {
+	if err := checkNodePosition(s.shape, level, index); err != nil {
+		return [32]byte{}, err
+	}
+	return s.nodes[s.shape.index(level, index)], nil
+}
+
+// Put overwrites the node at the given level and index.
+func (s *MmapStore) Put(level int, index uint64, node [32]byte) error {
+	if err := checkNodePosition(s.shape, level, index); err != nil {
+		return err
+	}
+	s.nodes[s.shape.index(level, index)] = node
+	return nil
+}
+
+// Close copies the bits of the bloom filter back to the heap, then unmaps and closes the file. The tree built with
+// the store must not be used afterwards.
+func (s *MmapStore) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	if s.holdsBits() {
+		if err := setBitSet(s.bf, s.bf.BitArray().Clone()); err != nil {
+			return err
+		}
+	}
+	var err error
+	if s.data != nil {
+		err = unmapFile(s.data)
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	s.data = nil
+	s.shape = treeShape{}
+	s.words = nil
+	s.nodes = nil
+	s.bits = nil
+	s.bf = nil
+	return err
+}
+
+// bytesToWords returns the words stored in b, without copying them.
+func bytesToWords(b []byte) []uint64 {
+	var words []uint64
+	if len(b) == 0 {
+		return words
+	}
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&words))
+	h.Data = uintptr(unsafe.Pointer(&b[0]))
+	h.Len = len(b) / 8
+	h.Cap = h.Len
+	return words
+}
+
+// wordsToBitSet returns a bit set of m bits backed by the words, without copying them. bitset.From always sets the
+// length to all bits of the words, so the length, the first field of the bit set, is set directly.
+func wordsToBitSet(words []uint64, m uint) (*bitset.BitSet, error) {
+	b := bitset.From(words)
+	*(*uint)(unsafe.Pointer(b)) = m
+	if b.Len() != m || len(b.Bytes()) != len(words) {
+		return nil, errors.New("the bit set cannot be backed by the words")
+	}
+	return b, nil
+}
+
+// bytesToNodes returns the nodes stored in b, without copying them.
+func bytesToNodes(b []byte) [][32]byte {
+	var nodes [][32]byte
+	if len(b) == 0 {
+		return nodes
+	}
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&nodes))
+	h.Data = uintptr(unsafe.Pointer(&b[0]))
+	h.Len = len(b) / 32
+	h.Cap = h.Len
+	return nodes
+}
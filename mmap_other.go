@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package bloomtree
+
+import (
+	"errors"
+	"os"
+)
+
+const mmapSupported = false
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("memory-mapped storage is not supported on this platform")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
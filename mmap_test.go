@@ -0,0 +1,174 @@
+package bloomtree
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestMmapStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	seed := "secret seed"
+	var tests = []struct {
+		name string
+		opts []Option
+	}{
+		{name: "padded layout", opts: []Option{WithChunkSize(128)}},
+		{name: "balanced layout", opts: []Option{WithLayout(BalancedLayout), WithHashMode(HardenedHashMode), WithWorkers(4)}},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, test.name)
+		store, err := NewMmapStore(path)
+		if err != nil {
+			t.Skip(err)
+		}
+		dbf := generateDBF(3000, seed, []byte{1}, []byte{2})
+		memory, err := NewBloomTree(dbf, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := NewBloomTree(dbf, append(test.opts, WithNodeStore(store))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Root() != memory.Root() {
+			t.Fatalf("expected root %s for the %s, but got %s", memory.Root(), test.name, tree.Root())
+		}
+		snapshot := tree.Snapshot()
+		for _, bt := range []*BloomTree{memory, tree} {
+			if err := bt.Add([]byte{byte(i + 3)}, []byte{4}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tree.Root() != memory.Root() {
+			t.Fatalf("expected root %s after adding elements for the %s, but got %s", memory.Root(), test.name, tree.Root())
+		}
+		for _, elem := range [][]byte{{1}, {4}, {9}} {
+			proof, err := tree.GenerateCompactMultiProof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := VerifyCompactMultiProof(elem, []byte(seed), proof, tree.Root(), dbf, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			} else if !verified {
+				t.Fatalf("failed to verify proof of element %v for the %s", elem, test.name)
+			}
+			proof, err = snapshot.GenerateCompactMultiProof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier, err := NewVerifier(snapshot.Root(), snapshot.Params(), []byte(seed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verified, err := verifier.Verify(elem, proof); err != nil || !verified {
+				t.Fatalf("failed to verify snapshot proof of element %v for the %s", elem, test.name)
+			}
+		}
+		snapshot.Release()
+
+		if _, err := NewBloomTree(dbf, WithNodeStore(store)); err == nil {
+			t.Fatalf("expected error for a second tree in the store of the %s", test.name)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shape := memory.shape
+		if string(data[:4]) != "BLMM" || binary.LittleEndian.Uint64(data[16:]) != uint64(shape.len()) {
+			t.Fatalf("unexpected header of the file of the %s", test.name)
+		}
+		var root Root
+		copy(root[:], data[len(data)-32:])
+		if root != memory.Root() {
+			t.Fatalf("expected the file of the %s to end with root %s, but got %s", test.name, memory.Root(), root)
+		}
+	}
+}
+
+// inMapping returns whether the words lie in the mapped file of the store.
+func inMapping(s *MmapStore, words []uint64) bool {
+	if len(words) == 0 || len(s.data) == 0 {
+		return false
+	}
+	start := uintptr(unsafe.Pointer(&s.data[0]))
+	first := uintptr(unsafe.Pointer(&words[0]))
+	last := uintptr(unsafe.Pointer(&words[len(words)-1]))
+	return first >= start && last+8 <= start+uintptr(len(s.data))
+}
+
+func TestMmapStoreFilterBits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewMmapStore(filepath.Join(dir, "tree"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer store.Close()
+	seed := "secret seed"
+	dbf := generateDBF(3000, seed, []byte{1}, []byte{2})
+	m := dbf.BitArray().Len()
+	if m%64 == 0 {
+		t.Fatalf("expected a bloom filter whose length is not a multiple of 64, but got %d bits", m)
+	}
+	words := append([]uint64(nil), dbf.BitArray().Bytes()...)
+	tree, err := NewBloomTree(dbf, WithNodeStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits := dbf.BitArray()
+	if !inMapping(store, bits.Bytes()) {
+		t.Fatal("expected the bits of the bloom filter to live in the mapping")
+	}
+	if bits.Len() != m || !equalWords(bits.Bytes(), words) {
+		t.Fatal("expected the bits of the bloom filter to be unchanged")
+	}
+	if err := tree.Add([]byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if !inMapping(store, dbf.BitArray().Bytes()) || !dbf.VerifyElement([]byte{3}) {
+		t.Fatal("expected added elements to be set in the mapping")
+	}
+
+	words = append([]uint64(nil), dbf.BitArray().Bytes()...)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if dbf.BitArray().Len() != m || !equalWords(dbf.BitArray().Bytes(), words) {
+		t.Fatal("expected Close to copy the bits of the bloom filter back")
+	}
+	if inMapping(store, dbf.BitArray().Bytes()) {
+		t.Fatal("expected the bits of the bloom filter to leave the mapping")
+	}
+
+	// the bits of a counting filter are derived from its counters and cannot be moved
+	other, err := NewMmapStore(filepath.Join(dir, "counting"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	cf, err := NewCountingFilter(1000, 4, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBloomTree(cf, WithNodeStore(other)); err == nil {
+		t.Fatal("expected error for a bloom filter whose bits cannot be replaced")
+	}
+}
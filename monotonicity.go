@@ -0,0 +1,95 @@
+package bloomtree
+
+import (
+	"errors"
+	"sort"
+)
+
+// MonotonicityProof proves that the bloom filter behind a newer root of a tree is a superset of the bloom filter
+// behind an older root: no bit was cleared in between. It carries the old and new words of every chunk that
+// changed, and one set of proof hashes for the rest of the tree. Since the same hashes reconstruct both roots,
+// every chunk outside the proof is the same in both trees.
+type MonotonicityProof struct {
+	ChunkIndices []uint64
+	OldChunks    [][]uint64
+	NewChunks    [][]uint64
+	Proof        [][32]byte
+}
+
+// MonotonicityProof returns a proof that the current state of the tree only added bits to the state of the
+// snapshot. It fails if a bit was cleared since the snapshot was taken. The snapshot must belong to the tree.
+func (bt *BloomTree) MonotonicityProof(since *Snapshot) (*MonotonicityProof, error) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	if since.tree != bt {
+		return nil, errors.New("the snapshot belongs to another tree")
+	}
+	if since.released {
+		return nil, errors.New("the snapshot was released")
+	}
+	step := uint64(bt.chunkSize / 64)
+	var chunkIndices []uint64
+	for i := range since.words {
+		chunkIndices = append(chunkIndices, uint64(i)/step)
+	}
+	sort.Slice(chunkIndices, func(i, j int) bool { return chunkIndices[i] < chunkIndices[j] })
+	chunkIndices = uniqueChunkIndices(chunkIndices)
+
+	mp := &MonotonicityProof{ChunkIndices: chunkIndices}
+	for _, index := range chunkIndices {
+		oldChunk := chunkWords(since, len(bt.words), index, bt.chunkSize)
+		newChunk := chunkWords(bt, len(bt.words), index, bt.chunkSize)
+		for i := range oldChunk {
+			if oldChunk[i]&^newChunk[i] != 0 {
+				return nil, errors.New("bits were cleared since the snapshot was taken")
+			}
+		}
+		mp.OldChunks = append(mp.OldChunks, oldChunk)
+		mp.NewChunks = append(mp.NewChunks, newChunk)
+	}
+	if len(chunkIndices) == 0 {
+		return mp, nil
+	}
+	proof, err := bt.generateProof(bt, chunkIndices)
+	if err != nil {
+		return nil, err
+	}
+	mp.Proof = proof
+	return mp, nil
+}
+
+// VerifyMonotonicityProof returns whether the proof shows that the bloom filter behind newRoot is a superset of
+// the bloom filter behind oldRoot, for trees with the given parameters.
+func VerifyMonotonicityProof(oldRoot, newRoot Root, params Params, proof *MonotonicityProof) (bool, error) {
+	if proof == nil {
+		return false, errors.New("there was no proof provided")
+	}
+	if err := params.validate(); err != nil {
+		return false, err
+	}
+	if len(proof.OldChunks) != len(proof.ChunkIndices) || len(proof.NewChunks) != len(proof.ChunkIndices) {
+		return false, errors.New("the proof does not match the chunk indices")
+	}
+	if len(proof.ChunkIndices) == 0 {
+		if len(proof.Proof) != 0 {
+			return false, errors.New("the proof contains unused hashes")
+		}
+		return oldRoot == newRoot, nil
+	}
+	for i, oldChunk := range proof.OldChunks {
+		newChunk := proof.NewChunks[i]
+		if len(oldChunk) != len(newChunk) {
+			return false, errors.New("the old and new chunks have different lengths")
+		}
+		for j := range oldChunk {
+			if oldChunk[j]&^newChunk[j] != 0 {
+				return false, nil
+			}
+		}
+	}
+	verified, err := verifyChunks(proof.ChunkIndices, proof.OldChunks, proof.Proof, oldRoot, params)
+	if err != nil || !verified {
+		return false, err
+	}
+	return verifyChunks(proof.ChunkIndices, proof.NewChunks, proof.Proof, newRoot, params)
+}
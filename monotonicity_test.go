@@ -0,0 +1,96 @@
+package bloomtree
+
+import (
+	"testing"
+)
+
+func TestMonotonicityProof(t *testing.T) {
+	seed := []byte("secret seed")
+	var tests = []struct {
+		name string
+		opts []Option
+	}{
+		{name: "padded layout", opts: []Option{WithChunkSize(128)}},
+		{name: "balanced layout", opts: []Option{WithLayout(BalancedLayout), WithHashMode(HardenedHashMode)}},
+	}
+	for _, test := range tests {
+		f, err := NewStandardFilter(3000, 4, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Add([]byte{1})
+		tree, err := NewBloomTree(f, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := tree.Snapshot()
+		unchanged, err := tree.MonotonicityProof(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verified, err := VerifyMonotonicityProof(old.Root(), tree.Root(), tree.Params(), unchanged); err != nil || !verified {
+			t.Fatalf("failed to verify the monotonicity proof of an unchanged tree for the %s", test.name)
+		}
+		for i := 2; i < 12; i++ {
+			if err := tree.Add([]byte{byte(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		proof, err := tree.MonotonicityProof(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verified, err := VerifyMonotonicityProof(old.Root(), tree.Root(), tree.Params(), proof); err != nil || !verified {
+			t.Fatalf("failed to verify the monotonicity proof for the %s", test.name)
+		}
+		if verified, _ := VerifyMonotonicityProof(tree.Root(), old.Root(), tree.Params(), proof); verified {
+			t.Fatalf("expected the swapped roots to fail for the %s", test.name)
+		}
+		if verified, _ := VerifyMonotonicityProof(old.Root(), old.Root(), tree.Params(), unchanged); !verified {
+			t.Fatalf("expected an empty proof to verify equal roots for the %s", test.name)
+		}
+		if verified, _ := VerifyMonotonicityProof(old.Root(), tree.Root(), tree.Params(), unchanged); verified {
+			t.Fatalf("expected an empty proof to fail for different roots for the %s", test.name)
+		}
+
+		// a proof that claims unchanged old bits, but hides a cleared bit in the new chunk
+		forged := *proof
+		forged.NewChunks = append([][]uint64(nil), proof.NewChunks...)
+		forged.NewChunks[0] = append([]uint64(nil), proof.OldChunks[0]...)
+		forged.NewChunks[0][0] &^= forged.NewChunks[0][0] & -forged.NewChunks[0][0]
+		if verified, _ := VerifyMonotonicityProof(old.Root(), tree.Root(), tree.Params(), &forged); verified {
+			t.Fatalf("expected error for a forged proof for the %s", test.name)
+		}
+		old.Release()
+		if _, err := tree.MonotonicityProof(old); err == nil {
+			t.Fatalf("expected error for a released snapshot for the %s", test.name)
+		}
+	}
+}
+
+func TestMonotonicityProofClearedBits(t *testing.T) {
+	f, err := NewCountingFilter(1000, 3, []byte("secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Add([]byte{1})
+	tree, err := NewBloomTree(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := tree.Snapshot()
+	defer old.Release()
+	if err := tree.Remove([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.MonotonicityProof(old); err == nil {
+		t.Fatal("expected error for cleared bits")
+	}
+	other, err := NewBloomTree(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.MonotonicityProof(old); err == nil {
+		t.Fatal("expected error for a snapshot of another tree")
+	}
+}
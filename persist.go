@@ -0,0 +1,250 @@
+package bloomtree
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/willf/bitset"
+)
+
+// treeFileMagic starts every encoded bloom tree.
+var treeFileMagic = [4]byte{'B', 'L', 'M', 'T'}
+
+// treeFileVersion is the version of the encoding of bloom trees written by WriteTo.
+const treeFileVersion = byte(1)
+
+// bitSetter is implemented by bloom filters whose bits can be replaced, such as the DBF package.
+type bitSetter interface {
+	SetBitSet(*bitset.BitSet)
+}
+
+// checkedBitSetter is implemented by bloom filters whose bits can be replaced and that check the new bits, such
+// as StandardFilter and BlockedFilter.
+type checkedBitSetter interface {
+	SetBitSet(*bitset.BitSet) error
+}
+
+// canSetBitSet returns whether the bits of the bloom filter can be replaced.
+func canSetBitSet(b BloomFilter) bool {
+	switch b.(type) {
+	case bitSetter, checkedBitSetter:
+		return true
+	}
+	return false
+}
+
+// setBitSet replaces the bits of a bloom filter that supports it, see canSetBitSet.
+func setBitSet(b BloomFilter, bits *bitset.BitSet) error {
+	switch s := b.(type) {
+	case checkedBitSetter:
+		return s.SetBitSet(bits)
+	case bitSetter:
+		s.SetBitSet(bits)
+		return nil
+	}
+	return errors.New("the bloom filter does not support replacing its bits")
+}
+
+// seedReference returns a fingerprint of the index derivation of a bloom filter: the hash of the indices of
+// a few fixed elements. Bloom filters with the same size, hash functions and seed have the same reference.
+func seedReference(b BloomFilter) [32]byte {
+	var data []byte
+	for i := 0; i < 4; i++ {
+		for _, index := range b.GetElementIndices([]byte{'p', 'r', 'o', 'b', 'e', byte(i)}) {
+			data = appendUint64(data, uint64(index))
+		}
+	}
+	return sha512.Sum512_256(data)
+}
+
+// WriteTo writes the bloom tree to w, so it can be restored with ReadBloomTree without rebuilding the nodes.
+// All integers are big endian:
+//
+//	magic           4 bytes "BLMT"
+//	version         1 byte
+//	m               8 bytes
+//	k               4 bytes
+//	chunk size      4 bytes
+//	hasher          1 byte
+//	hash mode       1 byte
+//	index scheme    1 byte
+//	layout          1 byte
+//	seed reference  32 bytes, a fingerprint of the index derivation of the bloom filter
+//	word count      8 bytes, followed by the bloom filter words, 8 bytes each
+//	node count      8 bytes, followed by the nodes, 32 bytes each
+//	checksum        32 bytes, SHA-512/256 of all previous bytes
+func (bt *BloomTree) WriteTo(w io.Writer) (int64, error) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	params := bt.Params()
+	h := sha512.New512_256()
+	bw := bufio.NewWriter(io.MultiWriter(w, h))
+	var n int64
+	write := func(data []byte) {
+		written, _ := bw.Write(data)
+		n += int64(written)
+	}
+	write(treeFileMagic[:])
+	write(appendTreeHeader([]byte{treeFileVersion}, params, seedReference(bt.bf)))
+	write(appendUint64(nil, uint64(len(bt.words))))
+	for _, word := range bt.words {
+		write(appendUint64(nil, word))
+	}
+	shape := bt.shape
+	write(appendUint64(nil, uint64(shape.len())))
+	for level, size := range shape.sizes {
+		for pos := 0; pos < size; pos++ {
+			node, err := bt.node(level, uint64(pos))
+			if err != nil {
+				return n, err
+			}
+			write(node[:])
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return n, err
+	}
+	written, err := w.Write(h.Sum(nil))
+	return n + int64(written), err
+}
+
+// ReadBloomTree restores a bloom tree written by WriteTo. The bloom filter must have the same size, hash functions
+// and seed as the bloom filter of the written tree. Its bits are replaced by the written bits if it has a
+// SetBitSet method, otherwise they must already equal the written bits. The nodes are not recomputed, the
+// checksum protects them against corruption.
+func ReadBloomTree(r io.Reader, b BloomFilter) (*BloomTree, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < sha512.Size256 {
+		return nil, errors.New("the encoded data is too short")
+	}
+	body := data[:len(data)-sha512.Size256]
+	sum := sha512.Sum512_256(body)
+	if !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, errors.New("the checksum of the bloom tree does not match")
+	}
+	br := &byteReader{data: body}
+	if !bytes.Equal(br.next(len(treeFileMagic)), treeFileMagic[:]) {
+		return nil, errors.New("the data is not an encoded bloom tree")
+	}
+	if version := br.byte(); br.err == nil && version != treeFileVersion {
+		return nil, fmt.Errorf("unsupported bloom tree encoding version %d", version)
+	}
+	params, ref := readTreeHeader(br)
+	wordCount := br.uint64()
+	if br.err != nil {
+		return nil, br.err
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if wordCount > uint64(len(br.data))/8 || wordCount != uint64(numWords(params.M)) {
+		return nil, errors.New("the number of words does not match the bloom filter")
+	}
+	words := make([]uint64, wordCount)
+	for i := range words {
+		words[i] = br.uint64()
+	}
+	nodeCount := br.uint64()
+	if br.err != nil {
+		return nil, br.err
+	}
+	shape := newTreeShape(numLeafs(len(words), params.ChunkSize), params.Layout)
+	if nodeCount != uint64(shape.len()) {
+		return nil, errors.New("the number of nodes does not match the tree")
+	}
+	nodes := make([][32]byte, shape.len())
+	for i := range nodes {
+		copy(nodes[i][:], br.next(32))
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	if len(br.data) != 0 {
+		return nil, errors.New("the encoded bloom tree has trailing bytes")
+	}
+	if err := restoreBloomFilter(b, params, ref, words); err != nil {
+		return nil, err
+	}
+	return &BloomTree{
+		bf:        b,
+		words:     words,
+		m:         params.M,
+		k:         params.K,
+		store:     &memoryStore{shape: shape, nodes: nodes},
+		root:      nodes[len(nodes)-1],
+		chunkSize: params.ChunkSize,
+		hasher:    params.Hasher,
+		mode:      params.HashMode,
+		layout:    params.Layout,
+		shape:     shape,
+	}, nil
+}
+
+// appendTreeHeader appends the parameters of a tree and the seed reference of its bloom filter to data.
+func appendTreeHeader(data []byte, params Params, ref [32]byte) []byte {
+	data = appendUint64(data, uint64(params.M))
+	data = appendUint32(data, uint32(params.K))
+	data = appendUint32(data, uint32(params.ChunkSize))
+	data = append(data, byte(params.Hasher), byte(params.HashMode), byte(params.IndexScheme), byte(params.Layout))
+	return append(data, ref[:]...)
+}
+
+// readTreeHeader reads the parameters and the seed reference written by appendTreeHeader.
+func readTreeHeader(br *byteReader) (Params, [32]byte) {
+	params := Params{
+		M:         uint(br.uint64()),
+		K:         uint(br.uint32()),
+		ChunkSize: int(br.uint32()),
+	}
+	params.Hasher = Hasher(br.byte())
+	params.HashMode = HashMode(br.byte())
+	params.IndexScheme = IndexScheme(br.byte())
+	params.Layout = Layout(br.byte())
+	var ref [32]byte
+	copy(ref[:], br.next(len(ref)))
+	return params, ref
+}
+
+// restoreBloomFilter checks that the bloom filter matches the parameters and the seed reference of a stored
+// tree, and restores the stored words into it.
+func restoreBloomFilter(b BloomFilter, params Params, ref [32]byte, words []uint64) error {
+	if b.BitArray().Len() != params.M || b.NumOfHashes() != params.K || indexSchemeOf(b) != params.IndexScheme {
+		return errors.New("the bloom filter does not match the parameters of the tree")
+	}
+	if seedReference(b) != ref {
+		return errors.New("the bloom filter does not match the seed of the tree")
+	}
+	if chunkSize, err := alignChunkSize(b, config{chunkSize: params.ChunkSize, chunkSizeSet: true}); err != nil || chunkSize != params.ChunkSize {
+		return errors.New("the chunk size of the tree does not match the bloom filter")
+	}
+	if canSetBitSet(b) {
+		bits := bitset.New(params.M)
+		copy(bits.Bytes(), words)
+		if err := setBitSet(b, bits); err != nil {
+			return err
+		}
+	} else if !equalWords(b.BitArray().Bytes(), words) {
+		return errors.New("the bits of the bloom filter do not match the tree")
+	}
+	return nil
+}
+
+func equalWords(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
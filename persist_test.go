@@ -0,0 +1,160 @@
+package bloomtree
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/labbloom/DBF"
+)
+
+func TestWriteAndReadBloomTree(t *testing.T) {
+	seed := "secret seed"
+	var elements [][]byte
+	for i := 0; i < 50; i++ {
+		elements = append(elements, []byte{byte(i)})
+	}
+	blocked, err := NewBlockedFilter(4096, 5, 256, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range elements {
+		blocked.Add(elem)
+	}
+	var tests = []struct {
+		name  string
+		bf    BloomFilter
+		empty func() BloomFilter
+		opts  []Option
+	}{
+		{
+			name:  "DBF",
+			bf:    generateDBF(200, seed, elements...),
+			empty: func() BloomFilter { return DBF.NewDbf(200, 0.2, []byte(seed)) },
+			opts:  []Option{WithChunkSize(128)},
+		},
+		{
+			name: "blocked filter",
+			bf:   blocked,
+			empty: func() BloomFilter {
+				f, _ := NewBlockedFilter(4096, 5, 256, []byte(seed))
+				return f
+			},
+			opts: []Option{WithHashMode(HardenedHashMode), WithLayout(BalancedLayout), WithHasher(BLAKE3)},
+		},
+	}
+
+	for _, test := range tests {
+		tree, err := NewBloomTree(test.bf, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		n, err := tree.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(buf.Len()) {
+			t.Fatalf("expected %d written bytes, but got %d", buf.Len(), n)
+		}
+		bf := test.empty()
+		restored, err := ReadBloomTree(bytes.NewReader(buf.Bytes()), bf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Root() != tree.Root() {
+			t.Fatalf("expected root %s for the %s, but got %s", tree.Root(), test.name, restored.Root())
+		}
+		if restored.Params() != tree.Params() {
+			t.Fatalf("expected params %+v for the %s, but got %+v", tree.Params(), test.name, restored.Params())
+		}
+		if !bf.BitArray().Equal(test.bf.BitArray()) {
+			t.Fatalf("expected the bits of the %s to be restored", test.name)
+		}
+		for _, elem := range [][]byte{{1}, {49}, {200}} {
+			proof, err := restored.GenerateCompactMultiProof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := VerifyCompactMultiProof(elem, []byte(seed), proof, tree.Root(), test.bf, test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			} else if !verified {
+				t.Fatalf("failed to verify proof of the restored tree of the %s", test.name)
+			}
+		}
+		if err := restored.Add([]byte{100}); err != nil {
+			t.Fatal(err)
+		}
+		rebuilt, err := NewBloomTree(bf, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Root() != rebuilt.Root() {
+			t.Fatalf("expected root %s after updating the restored tree of the %s, but got %s", rebuilt.Root(), test.name, restored.Root())
+		}
+	}
+}
+
+func TestReadBloomTreeInvalid(t *testing.T) {
+	seed := "secret seed"
+	tree, err := NewBloomTree(generateDBF(200, seed, []byte{1}, []byte{2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := tree.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[100] ^= 1
+	// a header that ends after a huge number of bits, with a valid checksum
+	header := append(treeFileMagic[:], treeFileVersion)
+	header = appendUint64(header, 1<<62)
+	sum := sha512.Sum512_256(header)
+	huge := append(header, sum[:]...)
+	var tests = []struct {
+		name string
+		data []byte
+		bf   BloomFilter
+	}{
+		{name: "corrupted data", data: corrupted, bf: DBF.NewDbf(200, 0.2, []byte(seed))},
+		{name: "truncated data", data: data[:len(data)-1], bf: DBF.NewDbf(200, 0.2, []byte(seed))},
+		{name: "other seed", data: data, bf: DBF.NewDbf(200, 0.2, []byte("other seed"))},
+		{name: "other size", data: data, bf: DBF.NewDbf(300, 0.2, []byte(seed))},
+		{name: "empty data", data: nil, bf: DBF.NewDbf(200, 0.2, []byte(seed))},
+		{name: "truncated header", data: huge, bf: DBF.NewDbf(200, 0.2, []byte(seed))},
+	}
+	for _, test := range tests {
+		if _, err := ReadBloomTree(bytes.NewReader(test.data), test.bf); err == nil {
+			t.Fatalf("expected error for %s", test.name)
+		}
+	}
+
+	// a bloom filter without SetBitSet must already hold the written bits
+	counting, err := NewCountingFilter(1000, 3, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting.Add([]byte{1})
+	tree, err = NewBloomTree(counting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if _, err := tree.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := NewCountingFilter(1000, 3, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBloomTree(bytes.NewReader(buf.Bytes()), empty); err == nil {
+		t.Fatal("expected error for a bloom filter with other bits and without SetBitSet")
+	}
+	if _, err := ReadBloomTree(bytes.NewReader(buf.Bytes()), counting); err != nil {
+		t.Fatal(err)
+	}
+}
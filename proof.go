@@ -4,25 +4,36 @@ import (
 	"errors"
 	"math"
 	"sort"
-
-	"github.com/willf/bitset"
 )
 
 type CompactMultiProof struct {
-	// Chunks are the leaves of the bloom tree, i.e. the bloom filter values for given parts of the bloom filter.
-	Chunks [][32]byte
+	// Chunks are the raw bloom filter words of the leaves needed by the proof, ordered by chunk index.
+	// The verifier hashes them into the leaves of the bloom tree and reads the element bits from them.
+	Chunks [][]uint64
 	// Proof are the hashes needed to reconstruct the bloom tree root.
 	Proof [][32]byte
 	// ProofType is 255 if the element is present in the bloom filter. it returns the index of the index if the element is not present in the bloom filter.
 	ProofType uint8
+	// ChunkSize is the number of bloom filter bits per chunk of the tree the proof was generated from.
+	ChunkSize int
+	// Hasher is the hash function of the tree the proof was generated from.
+	Hasher Hasher
+	// HashMode is the leaf and node encoding of the tree the proof was generated from.
+	HashMode HashMode
+	// Layout is the leaf arrangement of the tree the proof was generated from.
+	Layout Layout
 }
 
 // newMultiProof generates a Merkle proof
-func newCompactMultiProof(chunks [][32]byte, proof [][32]byte, proofType uint8) *CompactMultiProof {
+func newCompactMultiProof(chunks [][]uint64, proof [][32]byte, proofType uint8, params Params) *CompactMultiProof {
 	return &CompactMultiProof{
 		Chunks:    chunks,
 		Proof:     proof,
 		ProofType: proofType,
+		ChunkSize: params.ChunkSize,
+		Hasher:    params.Hasher,
+		HashMode:  params.HashMode,
+		Layout:    params.Layout,
 	}
 }
 
@@ -33,17 +44,34 @@ func CheckProofType(proofType uint8) bool {
 	return false
 }
 
-func checkChunkPresence(elemIndices []uint, bf *bitset.BitSet) bool {
+func checkChunkPresence(elemIndices []uint, chunkIndices []uint64, chunks [][]uint64, chunkSize int) (bool, error) {
 	for _, v := range elemIndices {
-		present := bf.Test(v)
+		chunk := sort.Search(len(chunkIndices), func(i int) bool { return chunkIndices[i] >= uint64(v)/uint64(chunkSize) })
+		if chunk == len(chunkIndices) || chunkIndices[chunk] != uint64(v)/uint64(chunkSize) {
+			return false, errors.New("the element index is not covered by the provided chunks")
+		}
+		present, err := testChunkBit(chunks[chunk], v, chunkSize)
+		if err != nil {
+			return false, err
+		}
 		if present != true {
-			return false
+			return false, nil
 		}
 	}
-	return true
+	return true, nil
+}
+
+// testChunkBit returns the bloom filter bit at index, read from the chunk that contains it.
+func testChunkBit(chunk []uint64, index uint, chunkSize int) (bool, error) {
+	offset := index % uint(chunkSize)
+	word := offset / 64
+	if word >= uint(len(chunk)) {
+		return false, errors.New("the chunk is too short to contain the element index")
+	}
+	return chunk[word]&(1<<(offset%64)) != 0, nil
 }
 
-func computeChunkIndices(elemIndices []uint) []uint64 {
+func computeChunkIndices(elemIndices []uint, chunkSize int) []uint64 {
 	chunkIndices := make([]uint64, len(elemIndices))
 	for i, v := range elemIndices {
 		index := uint64(math.Floor(float64(v) / float64(chunkSize)))
@@ -52,137 +80,192 @@ func computeChunkIndices(elemIndices []uint) []uint64 {
 	return chunkIndices
 }
 
-func determineOrder2Hash(ind1, indNeighbor int, h1, h2 [32]byte) [32]byte {
-	if ind1 > indNeighbor {
-		return hashChild(h2, h1)
+func uniqueChunkIndices(chunkIndices []uint64) []uint64 {
+	var unique []uint64
+	for i, v := range chunkIndices {
+		if i == 0 || chunkIndices[i-1] != v {
+			unique = append(unique, v)
+		}
 	}
-	return hashChild(h1, h2)
+	return unique
 }
 
-func verifyProof(chunkIndices []uint64, multiproof *CompactMultiProof, root [32]byte, treeLength int) (bool, error) {
-	var (
-		pairs        []int
-		newIndices   []uint64
-		newBlueNodes [][32]byte
-	)
-
-	proof := multiproof.Proof
-	blueNodes := multiproof.Chunks
-	prevIndices := chunkIndices
-	indMap := make(map[uint64]int)
-	leavesPerLayer := uint64(treeLength + 1)
-	currentLayer := uint64(0)
-	height := int(math.Log2(float64(treeLength / 2)))
-	// remove duplicates of blue nodes
-	var uniqueBlueNodes [][32]byte
-	uniqueBlueNodes = append(uniqueBlueNodes, blueNodes[0])
-	for i := 1; i < len(blueNodes); i++ {
-		if blueNodes[i] != blueNodes[i-1] {
-			uniqueBlueNodes = append(uniqueBlueNodes, blueNodes[i])
+// hashChunks returns the leaf hashes of the provided chunks. numWords is the length of the bloom filter in words,
+// every chunk must have exactly the length of the bloom filter part it claims to be.
+func hashChunks(th treeHasher, chunkIndices []uint64, chunks [][]uint64, numWords int) ([][32]byte, error) {
+	step := uint64(th.chunkSize / 64)
+	leafs := make([][32]byte, len(chunks))
+	for i, chunk := range chunks {
+		start := chunkIndices[i] * step
+		if start >= uint64(numWords) {
+			return nil, errors.New("the chunk index exceeds the bloom filter")
+		}
+		length := uint64(numWords) - start
+		if length > step {
+			length = step
+		}
+		if uint64(len(chunk)) != length {
+			return nil, errors.New("the chunk has an invalid length")
 		}
+		leafs[i] = th.leaf(chunkIndices[i], chunk...)
 	}
-	blueNodes = uniqueBlueNodes
+	return leafs, nil
+}
 
-	// remove duplicates of proof
-	var uniqueProof [][32]byte
-	if len(proof) != 0 {
-		uniqueProof = append(uniqueProof, proof[0])
-		for i := 1; i < len(proof); i++ {
-			if proof[i] != proof[i-1] {
-				uniqueProof = append(uniqueProof, proof[i])
-			}
-		}
+// verifyProof returns whether the leaves at the given unique, sorted chunk indices and the proof hashes
+// reconstruct the root of a tree with the given shape.
+func verifyProof(th treeHasher, shape treeShape, chunkIndices []uint64, leafs [][32]byte, proof [][32]byte, root [32]byte) (bool, error) {
+	if len(chunkIndices) == 0 || len(chunkIndices) != len(leafs) {
+		return false, errors.New("the proof does not match the chunk indices")
 	}
-	proof = uniqueProof
+	known := chunkIndices
+	nodes := leafs
 	proofNum := 0
-	for i := 0; i <= height; i++ {
-		if len(newIndices) != 0 {
-			for j := 0; j < len(newIndices); j += 2 {
-				prevIndices = append(prevIndices, newIndices[j]/2)
+	for level := 0; level < shape.height(); level++ {
+		size := uint64(shape.sizes[level])
+		var parents []uint64
+		var parentNodes [][32]byte
+		for i := 0; i < len(known); i++ {
+			pos := known[i]
+			if pos >= size || (i > 0 && pos <= known[i-1]) {
+				return false, errors.New("the proof does not match the chunk indices")
 			}
-			newIndices = nil
-		}
-		for _, val := range prevIndices {
-			neighbor := val ^ 1
-			if _, ok := indMap[val+neighbor]; ok {
-				if indMap[val+neighbor] != int(val) {
-					indMap[val+neighbor] = -1
+			sibling := pos ^ 1
+			var parent [32]byte
+			if i+1 < len(known) && known[i+1] == sibling {
+				parent = th.child(nodes[i], nodes[i+1])
+				i++
+			} else if sibling < size {
+				if proofNum >= len(proof) {
+					return false, errors.New("the proof does not match the chunk indices")
+				}
+				if pos%2 == 0 {
+					parent = th.child(nodes[i], proof[proofNum])
+				} else {
+					parent = th.child(proof[proofNum], nodes[i])
 				}
-			} else {
-				indMap[val+neighbor] = int(val)
-				pairs = append(pairs, int(val+neighbor))
-			}
-		}
-		for k, v := range indMap {
-			if v == -1 {
-				a, b := order((k-1)/2, (k+1)/2)
-				newIndices = append(newIndices, a, b)
-			} else {
-				a, b := order(uint64(v), k-uint64(v))
-				newIndices = append(newIndices, a, b)
-			}
-		}
-		sort.Ints(pairs)
-		blueNodeNum := 0
-		for _, v := range pairs {
-			value := uint64(v)
-			if indMap[value] == -1 {
-				newBlueNodes = append(newBlueNodes, hashChild(blueNodes[blueNodeNum], blueNodes[blueNodeNum+1]))
-				blueNodeNum += 2
-			} else {
-				newBlueNodes = append(newBlueNodes, determineOrder2Hash(indMap[value], v-indMap[value], blueNodes[blueNodeNum], proof[proofNum]))
-				blueNodeNum++
 				proofNum++
+			} else {
+				parent = nodes[i]
 			}
+			parents = append(parents, pos/2)
+			parentNodes = append(parentNodes, parent)
 		}
-		blueNodes = newBlueNodes
-		newBlueNodes = nil
-		blueNodeNum = 0
-		indMap = make(map[uint64]int)
-		pairs = nil
-		leavesPerLayer /= 2
-		currentLayer += leavesPerLayer
-		prevIndices = nil
-	}
-	if blueNodes[0] == root {
+		known = parents
+		nodes = parentNodes
+	}
+	if proofNum != len(proof) {
+		return false, errors.New("the proof contains unused hashes")
+	}
+	if known[0] != 0 || len(nodes) != 1 {
+		return false, errors.New("the proof does not match the chunk indices")
+	}
+	if nodes[0] == root {
 		return true, nil
 	}
 	return false, nil
 }
 
+// verifyChunks returns whether the chunks at the given unique, sorted chunk indices and the proof hashes
+// reconstruct the root of a tree with the given parameters.
+func verifyChunks(chunkIndices []uint64, chunks [][]uint64, proof [][32]byte, root [32]byte, params Params) (bool, error) {
+	th := newTreeHasher(params)
+	numWords := numWords(params.M)
+	leafs, err := hashChunks(th, chunkIndices, chunks, numWords)
+	if err != nil {
+		return false, err
+	}
+	shape := newTreeShape(numLeafs(numWords, params.ChunkSize), params.Layout)
+	return verifyProof(th, shape, chunkIndices, leafs, proof, root)
+}
+
 // VerifyCompactMultiProof return whether the multi proof provided is true or false.
-// The proof type can be absence or presence
-func VerifyCompactMultiProof(element, seedValue []byte, multiproof *CompactMultiProof, root [32]byte, bf BloomFilter) (bool, error) {
+// The proof type can be absence or presence. The element bits are read from the chunks carried by the proof,
+// the bloom filter is only used to map the element to its indices and to determine the size of the tree.
+// The options must be the ones the tree was created with, a proof of a tree with a different chunk size,
+// hasher, hash mode or layout is rejected.
+func VerifyCompactMultiProof(element, seedValue []byte, multiproof *CompactMultiProof, root [32]byte, bf BloomFilter, opts ...Option) (bool, error) {
 	// find length of the tree
 	dbfBytes := len(bf.BitArray().Bytes())
 	if dbfBytes == 0 {
 		return false, errors.New("there was no bloom filter provided")
 	}
-	treeLeafs := int(math.Exp2(math.Ceil(math.Log2(float64(dbfBytes) / float64(chunkSize/64)))))
-	treeLength := (treeLeafs * 2) - 1
+	if multiproof == nil {
+		return false, errors.New("there was no proof provided")
+	}
+	params, err := verifierParams(bf, opts)
+	if err != nil {
+		return false, err
+	}
+	if err := params.checkProof(multiproof.ChunkSize, multiproof.Hasher, multiproof.HashMode, multiproof.Layout); err != nil {
+		return false, err
+	}
 	elemIndices := bf.MapElementToBF(element, seedValue)
-	elemIndicesCopy := elemIndices
+	return verifyElementProof(elemIndices, multiproof, root, params)
+}
+
+// verifierParams returns the parameters of a tree created from the bloom filter with the options.
+func verifierParams(bf BloomFilter, opts []Option) (Params, error) {
+	c, err := newConfig(bf, defaultChunkSize, opts)
+	if err != nil {
+		return Params{}, err
+	}
+	return Params{
+		M:           bf.BitArray().Len(),
+		K:           bf.NumOfHashes(),
+		ChunkSize:   c.chunkSize,
+		Hasher:      c.hasher,
+		HashMode:    c.mode,
+		IndexScheme: indexSchemeOf(bf),
+		Layout:      c.layout,
+	}, nil
+}
+
+// verifyElementProof verifies the multi proof of an element with the given indices against the root of a tree
+// with the given parameters.
+func verifyElementProof(elemIndices []uint, multiproof *CompactMultiProof, root [32]byte, params Params) (bool, error) {
+	if err := params.validate(); err != nil {
+		return false, err
+	}
+	chunkSize := params.ChunkSize
 	if CheckProofType(multiproof.ProofType) {
-		sort.Slice(elemIndices, func(i, j int) bool { return elemIndices[i] < elemIndices[j] })
-		chunkIndices := computeChunkIndices(elemIndices)
-		present := checkChunkPresence(elemIndices, bf.BitArray())
+		sorted := make([]uint, len(elemIndices))
+		copy(sorted, elemIndices)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		chunkIndices := computeChunkIndices(sorted, chunkSize)
+		unique := uniqueChunkIndices(chunkIndices)
+		if len(unique) != len(multiproof.Chunks) {
+			return false, errors.New("the element is not inside the provided chunks for a presence proof")
+		}
+		present, err := checkChunkPresence(sorted, unique, multiproof.Chunks, chunkSize)
+		if err != nil {
+			return false, err
+		}
 		if present != true {
 			return false, errors.New("the element is not inside the provided chunks for a presence proof")
 		}
-		verify, err := verifyProof(chunkIndices, multiproof, root, treeLength)
+		verify, err := verifyChunks(unique, multiproof.Chunks, multiproof.Proof, root, params)
 		if err != nil {
 			return false, err
 		}
 		return verify, nil //verify, err
 	}
-	index := []uint{elemIndicesCopy[int(multiproof.ProofType)]}
-	chunkIndices := computeChunkIndices(index)
-
-	present := checkChunkPresence(index, bf.BitArray())
+	if int(multiproof.ProofType) >= len(elemIndices) {
+		return false, errors.New("the proof type exceeds the number of element indices")
+	}
+	index := []uint{elemIndices[int(multiproof.ProofType)]}
+	chunkIndices := computeChunkIndices(index, chunkSize)
+	if len(multiproof.Chunks) != 1 {
+		return false, errors.New("the element cannot be inside the provided chunk for an absence proof")
+	}
+	present, err := checkChunkPresence(index, chunkIndices, multiproof.Chunks, chunkSize)
+	if err != nil {
+		return false, err
+	}
 	if present == true {
 		return false, errors.New("the element cannot be inside the provided chunk for an absence proof")
 	}
-	verify, err := verifyProof(chunkIndices, multiproof, root, treeLength)
+	verify, err := verifyChunks(chunkIndices, multiproof.Chunks, multiproof.Proof, root, params)
 	if err != nil {
 		return false, err
 	}
@@ -186,11 +186,59 @@ func TestAbsenceProofPresentElement(t *testing.T) {
 		if CheckProofType(multiproof.ProofType) != false {
 			t.Fatal("proof type is not absent")
 		}
-		_, err = VerifyCompactMultiProof(test.element, []byte(seed), multiproof, tree.Root(), tree.GetBloomFilter())
-		if err == nil {
-			t.Fatalf("expected error: %v", errors.New("the element cannot be inside the provided chunk for an absence proof"))
-		} else if err.Error() != errors.New("the element cannot be inside the provided chunk for an absence proof").Error() {
-			t.Fatalf("expected error %v, but got %v", errors.New("the element cannot be inside the provided chunk for an absence proof"), err)
+		absent, err := VerifyCompactMultiProof(test.element, []byte(seed), multiproof, tree.Root(), tree.GetBloomFilter())
+		if err == nil && absent {
+			t.Fatal("expected the absence proof of another element to be rejected")
 		}
 	}
 }
+
+func TestAbsenceProofTamperedChunk(t *testing.T) {
+	seed := "secret seed"
+	element := []byte{9}
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}}...)
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof(element)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckProofType(multiproof.ProofType) != false {
+		t.Fatal("proof type is not absent")
+	}
+	// set the zero bit the absence proof relies on
+	offset := dbf.GetElementIndices(element)[multiproof.ProofType] % uint(multiproof.ChunkSize)
+	multiproof.Chunks[0][offset/64] |= 1 << (offset % 64)
+
+	_, err = VerifyCompactMultiProof(element, []byte(seed), multiproof, tree.Root(), tree.GetBloomFilter())
+	if err == nil {
+		t.Fatalf("expected error: %v", errors.New("the element cannot be inside the provided chunk for an absence proof"))
+	} else if err.Error() != errors.New("the element cannot be inside the provided chunk for an absence proof").Error() {
+		t.Fatalf("expected error %v, but got %v", errors.New("the element cannot be inside the provided chunk for an absence proof"), err)
+	}
+}
+
+func TestPresenceProofTamperedChunk(t *testing.T) {
+	seed := "secret seed"
+	element := []byte{1}
+	dbf := generateDBF(200, seed, [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}}...)
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof(element)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// set the lowest zero bit of the chunk, the chunk no longer hashes to the committed leaf
+	chunk := multiproof.Chunks[0]
+	chunk[0] |= chunk[0] + 1
+	present, err := VerifyCompactMultiProof(element, []byte(seed), multiproof, tree.Root(), tree.GetBloomFilter())
+	if err != nil {
+		t.Fatal(err)
+	} else if present {
+		t.Fatal("expected a tampered chunk to be rejected")
+	}
+}
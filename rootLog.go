@@ -0,0 +1,276 @@
+package bloomtree
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RootLog is an append-only history of the roots a bloom tree was published with. The position of a root in the
+// log is its epoch. The log is itself a Merkle tree as in RFC 6962: the leaf of a root is H(0x00 || root), an
+// internal node is H(0x01 || left || right), and the head of a log of n roots is the root of the left-balanced
+// tree over the first n leaves. Inclusion proofs show that a root was published at an epoch, and consistency
+// proofs show that a later head only appended roots to an earlier head, so a publisher cannot rewrite history
+// without being detected.
+//
+// A RootLog is safe for concurrent use.
+type RootLog struct {
+	mu        sync.RWMutex
+	hasher    Hasher
+	roots     []Root
+	leaves    [][32]byte
+	snapshots map[uint64]*Snapshot
+}
+
+// NewRootLog creates an empty log that hashes with the given hasher.
+func NewRootLog(h Hasher) (*RootLog, error) {
+	if !h.Valid() {
+		return nil, fmt.Errorf("unknown hasher %v", h)
+	}
+	return &RootLog{hasher: h, snapshots: make(map[uint64]*Snapshot)}, nil
+}
+
+// Append adds a root to the log and returns its epoch.
+func (l *RootLog) Append(root Root) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.append(root)
+}
+
+func (l *RootLog) append(root Root) uint64 {
+	l.roots = append(l.roots, root)
+	l.leaves = append(l.leaves, logLeaf(l.hasher, root))
+	return uint64(len(l.roots) - 1)
+}
+
+// Publish takes a snapshot of the tree and appends its root to the log. The log keeps the snapshot, so proofs
+// for the root of every published epoch can still be generated after the tree changed, until the snapshot is
+// released with ReleaseBefore.
+func (l *RootLog) Publish(bt *BloomTree) (uint64, *Snapshot) {
+	s := bt.Snapshot()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	epoch := l.append(s.Root())
+	l.snapshots[epoch] = s
+	return epoch, s
+}
+
+// Snapshot returns the snapshot of the tree published at the epoch.
+func (l *RootLog) Snapshot(epoch uint64) (*Snapshot, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s, ok := l.snapshots[epoch]
+	if !ok {
+		return nil, fmt.Errorf("the log holds no snapshot of epoch %d", epoch)
+	}
+	return s, nil
+}
+
+// ReleaseBefore releases the snapshots of all epochs before the given epoch. Their roots stay in the log.
+func (l *RootLog) ReleaseBefore(epoch uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for e, s := range l.snapshots {
+		if e < epoch {
+			s.Release()
+			delete(l.snapshots, e)
+		}
+	}
+}
+
+// Size returns the number of roots in the log.
+func (l *RootLog) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(len(l.roots))
+}
+
+// Root returns the root published at the epoch.
+func (l *RootLog) Root(epoch uint64) (Root, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if epoch >= uint64(len(l.roots)) {
+		return Root{}, fmt.Errorf("the log has no epoch %d", epoch)
+	}
+	return l.roots[epoch], nil
+}
+
+// Head returns the head of the log over its first size roots.
+func (l *RootLog) Head(size uint64) (Root, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size > uint64(len(l.leaves)) {
+		return Root{}, fmt.Errorf("the log has fewer than %d roots", size)
+	}
+	if size == 0 {
+		return l.hasher.Sum(nil), nil
+	}
+	return logHead(l.hasher, l.leaves[:size]), nil
+}
+
+// InclusionProof returns the hashes that prove that the root of the epoch is in the head of the log over its
+// first size roots.
+func (l *RootLog) InclusionProof(epoch, size uint64) ([][32]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("the log has fewer than %d roots", size)
+	}
+	if epoch >= size {
+		return nil, fmt.Errorf("epoch %d is not in a log of %d roots", epoch, size)
+	}
+	return logPath(l.hasher, epoch, l.leaves[:size]), nil
+}
+
+// ConsistencyProof returns the hashes that prove that the head over the first newSize roots only appended
+// roots to the head over the first oldSize roots.
+func (l *RootLog) ConsistencyProof(oldSize, newSize uint64) ([][32]byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if newSize > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("the log has fewer than %d roots", newSize)
+	}
+	if oldSize == 0 || oldSize > newSize {
+		return nil, fmt.Errorf("no consistency proof from %d to %d roots", oldSize, newSize)
+	}
+	return logSubproof(l.hasher, oldSize, l.leaves[:newSize], true), nil
+}
+
+func logLeaf(h Hasher, root Root) [32]byte {
+	return h.Sum(append([]byte{leafPrefix}, root[:]...))
+}
+
+// largestPowerOfTwoBelow returns the largest power of two smaller than n, for n > 1.
+func largestPowerOfTwoBelow(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func logHead(h Hasher, leaves [][32]byte) [32]byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := largestPowerOfTwoBelow(uint64(len(leaves)))
+	return hashChildHardened(h, logHead(h, leaves[:k]), logHead(h, leaves[k:]))
+}
+
+func logPath(h Hasher, m uint64, leaves [][32]byte) [][32]byte {
+	n := uint64(len(leaves))
+	if n == 1 {
+		return nil
+	}
+	k := largestPowerOfTwoBelow(n)
+	if m < k {
+		return append(logPath(h, m, leaves[:k]), logHead(h, leaves[k:]))
+	}
+	return append(logPath(h, m-k, leaves[k:]), logHead(h, leaves[:k]))
+}
+
+func logSubproof(h Hasher, m uint64, leaves [][32]byte, complete bool) [][32]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][32]byte{logHead(h, leaves)}
+	}
+	k := largestPowerOfTwoBelow(n)
+	if m <= k {
+		return append(logSubproof(h, m, leaves[:k], complete), logHead(h, leaves[k:]))
+	}
+	return append(logSubproof(h, m-k, leaves[k:], false), logHead(h, leaves[:k]))
+}
+
+// VerifyLogInclusion checks that the root was published at the epoch of the log with the given head and size.
+func VerifyLogInclusion(h Hasher, root Root, epoch, size uint64, proof [][32]byte, head Root) error {
+	if !h.Valid() {
+		return fmt.Errorf("unknown hasher %v", h)
+	}
+	if epoch >= size {
+		return fmt.Errorf("epoch %d is not in a log of %d roots", epoch, size)
+	}
+	fn, sn := epoch, size-1
+	r := logLeaf(h, root)
+	for _, p := range proof {
+		if sn == 0 {
+			return errors.New("the inclusion proof has too many hashes")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = hashChildHardened(h, p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = hashChildHardened(h, r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("the inclusion proof has too few hashes")
+	}
+	if r != head {
+		return errors.New("the inclusion proof does not match the head of the log")
+	}
+	return nil
+}
+
+// VerifyLogConsistency checks that the log with newHead over newSize roots only appended roots to the log with
+// oldHead over oldSize roots.
+func VerifyLogConsistency(h Hasher, oldSize, newSize uint64, oldHead, newHead Root, proof [][32]byte) error {
+	if !h.Valid() {
+		return fmt.Errorf("unknown hasher %v", h)
+	}
+	if oldSize == 0 || oldSize > newSize {
+		return fmt.Errorf("no consistency proof from %d to %d roots", oldSize, newSize)
+	}
+	if oldSize == newSize {
+		if len(proof) != 0 {
+			return errors.New("the consistency proof of equal sizes must be empty")
+		}
+		if oldHead != newHead {
+			return errors.New("the heads of logs of equal size differ")
+		}
+		return nil
+	}
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][32]byte{oldHead}, proof...)
+	}
+	if len(proof) == 0 {
+		return errors.New("the consistency proof is empty")
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("the consistency proof has too many hashes")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = hashChildHardened(h, c, fr)
+			sr = hashChildHardened(h, c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = hashChildHardened(h, sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("the consistency proof has too few hashes")
+	}
+	if fr != oldHead || sr != newHead {
+		return errors.New("the consistency proof does not match the heads of the log")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package bloomtree
+
+import (
+	"testing"
+)
+
+func TestRootLogProofs(t *testing.T) {
+	log, err := NewRootLog(SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	for i := 0; i < n; i++ {
+		if epoch := log.Append(Root{byte(i)}); epoch != uint64(i) {
+			t.Fatalf("expected epoch %d, but got %d", i, epoch)
+		}
+	}
+	heads := make([]Root, n+1)
+	for size := uint64(0); size <= n; size++ {
+		heads[size], err = log.Head(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the head of three roots as in RFC 6962: H(0x01 || H(0x01 || l0 || l1) || l2)
+	l := func(i byte) [32]byte { return logLeaf(SHA256, Root{i}) }
+	if want := hashChildHardened(SHA256, hashChildHardened(SHA256, l(0), l(1)), l(2)); heads[3] != want {
+		t.Fatalf("expected head %s of three roots, but got %s", Root(want), heads[3])
+	}
+
+	for size := uint64(1); size <= n; size++ {
+		for epoch := uint64(0); epoch < size; epoch++ {
+			proof, err := log.InclusionProof(epoch, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyLogInclusion(SHA256, Root{byte(epoch)}, epoch, size, proof, heads[size]); err != nil {
+				t.Fatalf("failed to verify inclusion of epoch %d in %d roots: %v", epoch, size, err)
+			}
+			if err := VerifyLogInclusion(SHA256, Root{byte(epoch + 1)}, epoch, size, proof, heads[size]); err == nil {
+				t.Fatalf("expected error for another root at epoch %d in %d roots", epoch, size)
+			}
+			if size > 1 {
+				if err := VerifyLogInclusion(SHA256, Root{byte(epoch)}, epoch, size, proof[1:], heads[size]); err == nil {
+					t.Fatalf("expected error for a truncated inclusion proof of epoch %d in %d roots", epoch, size)
+				}
+			}
+		}
+		for oldSize := uint64(1); oldSize <= size; oldSize++ {
+			proof, err := log.ConsistencyProof(oldSize, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyLogConsistency(SHA256, oldSize, size, heads[oldSize], heads[size], proof); err != nil {
+				t.Fatalf("failed to verify consistency from %d to %d roots: %v", oldSize, size, err)
+			}
+			if oldSize < size {
+				forged := heads[oldSize]
+				forged[0] ^= 1
+				if err := VerifyLogConsistency(SHA256, oldSize, size, forged, heads[size], proof); err == nil {
+					t.Fatalf("expected error for a rewritten log from %d to %d roots", oldSize, size)
+				}
+			}
+		}
+	}
+	if _, err := log.InclusionProof(n, n); err == nil {
+		t.Fatal("expected error for an epoch outside the log")
+	}
+	if _, err := log.ConsistencyProof(0, n); err == nil {
+		t.Fatal("expected error for a consistency proof from an empty log")
+	}
+	if _, err := log.Head(n + 1); err == nil {
+		t.Fatal("expected error for a head beyond the log")
+	}
+}
+
+func TestRootLogPublish(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(500, seed)
+	tree, err := NewBloomTree(dbf, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := NewRootLog(SHA512_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tree.Add([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		epoch, snapshot := log.Publish(tree)
+		if root, _ := log.Root(epoch); root != snapshot.Root() || root != tree.Root() {
+			t.Fatalf("expected root %s at epoch %d, but got %s", tree.Root(), epoch, root)
+		}
+	}
+	if err := tree.Add([]byte{10}); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := log.Snapshot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := snapshot.GenerateCompactMultiProof([]byte{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(snapshot.Root(), snapshot.Params(), []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := verifier.Verify([]byte{2}, proof); err != nil || !verified {
+		t.Fatal("failed to verify proof of the snapshot of epoch 1")
+	} else if CheckProofType(proof.ProofType) {
+		t.Fatal("expected an absence proof at epoch 1")
+	}
+
+	log.ReleaseBefore(2)
+	if _, err := log.Snapshot(1); err == nil {
+		t.Fatal("expected error for a released epoch")
+	}
+	if _, err := log.Root(1); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot in use, but got %d", len(tree.snapshots))
+	}
+}
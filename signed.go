@@ -0,0 +1,214 @@
+package bloomtree
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// signedRootDomain prefixes the signed message of a SignedRoot, so the signature cannot be mistaken for a
+// signature over other data.
+const signedRootDomain = "bloomtree signed root v1\x00"
+
+// signedRootVersion is the version of the binary encoding of signed roots.
+const signedRootVersion = byte(1)
+
+// KeyID identifies the public key of a signer. For Ed25519 keys it is the SHA-512/256 hash of the public key.
+type KeyID [32]byte
+
+func (id KeyID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes the key ID as a hex string.
+func (id KeyID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes a key ID from a hex string.
+func (id *KeyID) UnmarshalText(text []byte) error {
+	h, err := decodeHexHash(string(text))
+	if err != nil {
+		return err
+	}
+	*id = h
+	return nil
+}
+
+// Ed25519KeyID returns the key ID of an Ed25519 public key.
+func Ed25519KeyID(pub ed25519.PublicKey) KeyID {
+	return sha512.Sum512_256(pub)
+}
+
+// Signer signs root commitments.
+type Signer interface {
+	KeyID() KeyID
+	Sign(message []byte) ([]byte, error)
+}
+
+// Ed25519Signer signs root commitments with an Ed25519 private key.
+type Ed25519Signer struct {
+	key ed25519.PrivateKey
+	id  KeyID
+}
+
+// NewEd25519Signer creates a signer for the private key.
+func NewEd25519Signer(key ed25519.PrivateKey) (*Ed25519Signer, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	return &Ed25519Signer{key: key, id: Ed25519KeyID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// KeyID returns the key ID of the public key of the signer.
+func (s *Ed25519Signer) KeyID() KeyID {
+	return s.id
+}
+
+// Sign returns the Ed25519 signature of the message.
+func (s *Ed25519Signer) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, message), nil
+}
+
+// SignedRoot is a root of a bloom tree authenticated by its publisher. It binds the root to the tree parameters,
+// the number of elements in the bloom filter, the time of signing and an epoch chosen by the publisher, for
+// example a counter of published roots.
+type SignedRoot struct {
+	Root      Root
+	Params    Params
+	Count     uint64
+	Timestamp time.Time
+	Epoch     uint64
+	KeyID     KeyID
+	Signature []byte
+}
+
+// NewSignedRoot signs the root and its metadata with the signer.
+func NewSignedRoot(s Signer, root Root, params Params, count, epoch uint64, timestamp time.Time) (*SignedRoot, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	sr := &SignedRoot{
+		Root:      root,
+		Params:    params,
+		Count:     count,
+		Timestamp: time.Unix(0, timestamp.UnixNano()).UTC(),
+		Epoch:     epoch,
+		KeyID:     s.KeyID(),
+	}
+	sig, err := s.Sign(sr.message())
+	if err != nil {
+		return nil, err
+	}
+	sr.Signature = sig
+	return sr, nil
+}
+
+// SignRoot signs the current root of the tree with the signer. The count is the number of elements in the
+// bloom filter, which the tree does not track itself.
+func (bt *BloomTree) SignRoot(s Signer, count, epoch uint64) (*SignedRoot, error) {
+	return NewSignedRoot(s, bt.Root(), bt.Params(), count, epoch, time.Now())
+}
+
+// message returns the bytes covered by the signature. All integers are big endian:
+//
+//	domain      "bloomtree signed root v1" and a zero byte
+//	root        32 bytes
+//	m           8 bytes
+//	k           4 bytes
+//	chunk size  4 bytes
+//	hasher, hash mode, index scheme, layout  1 byte each
+//	count       8 bytes
+//	timestamp   8 bytes, nanoseconds since the Unix epoch
+//	epoch       8 bytes
+//	key ID      32 bytes
+func (sr *SignedRoot) message() []byte {
+	data := append([]byte(signedRootDomain), sr.Root[:]...)
+	data = appendUint64(data, uint64(sr.Params.M))
+	data = appendUint32(data, uint32(sr.Params.K))
+	data = appendUint32(data, uint32(sr.Params.ChunkSize))
+	data = append(data, byte(sr.Params.Hasher), byte(sr.Params.HashMode), byte(sr.Params.IndexScheme), byte(sr.Params.Layout))
+	data = appendUint64(data, sr.Count)
+	data = appendUint64(data, uint64(sr.Timestamp.UnixNano()))
+	data = appendUint64(data, sr.Epoch)
+	return append(data, sr.KeyID[:]...)
+}
+
+// VerifySignedRoot checks that the signed root was signed with the private key of the Ed25519 public key.
+func VerifySignedRoot(sr *SignedRoot, pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return errors.New("invalid ed25519 public key")
+	}
+	if sr.KeyID != Ed25519KeyID(pub) {
+		return errors.New("the signed root was signed with another key")
+	}
+	if err := sr.Params.validate(); err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, sr.message(), sr.Signature) {
+		return errors.New("the signature of the root is invalid")
+	}
+	return nil
+}
+
+// AuthenticatedRoot returns the root after checking its signature, so it can be passed on to
+// VerifyCompactMultiProof or VerifyBatchProof.
+func (sr *SignedRoot) AuthenticatedRoot(pub ed25519.PublicKey) (Root, error) {
+	if err := VerifySignedRoot(sr, pub); err != nil {
+		return Root{}, err
+	}
+	return sr.Root, nil
+}
+
+// NewSignedRootVerifier creates a verifier for the signed root after checking its signature.
+func NewSignedRootVerifier(sr *SignedRoot, pub ed25519.PublicKey, seed []byte) (*Verifier, error) {
+	if err := VerifySignedRoot(sr, pub); err != nil {
+		return nil, err
+	}
+	return NewVerifier(sr.Root, sr.Params, seed)
+}
+
+// MarshalBinary encodes the signed root as its signed message, without the domain prefix, followed by a 4 byte
+// length prefixed signature. The first byte is the version of the encoding.
+func (sr *SignedRoot) MarshalBinary() ([]byte, error) {
+	msg := sr.message()[len(signedRootDomain):]
+	data := append([]byte{signedRootVersion}, msg...)
+	data = appendUint32(data, uint32(len(sr.Signature)))
+	return append(data, sr.Signature...), nil
+}
+
+// UnmarshalBinary decodes a signed root encoded by MarshalBinary. It does not check the signature.
+func (sr *SignedRoot) UnmarshalBinary(data []byte) error {
+	br := &byteReader{data: data}
+	if version := br.byte(); br.err == nil && version != signedRootVersion {
+		return errors.New("unsupported signed root encoding version")
+	}
+	var decoded SignedRoot
+	copy(decoded.Root[:], br.next(32))
+	decoded.Params.M = uint(br.uint64())
+	decoded.Params.K = uint(br.uint32())
+	decoded.Params.ChunkSize = int(br.uint32())
+	decoded.Params.Hasher = Hasher(br.byte())
+	decoded.Params.HashMode = HashMode(br.byte())
+	decoded.Params.IndexScheme = IndexScheme(br.byte())
+	decoded.Params.Layout = Layout(br.byte())
+	decoded.Count = br.uint64()
+	decoded.Timestamp = time.Unix(0, int64(br.uint64())).UTC()
+	decoded.Epoch = br.uint64()
+	copy(decoded.KeyID[:], br.next(32))
+	n := br.count(1)
+	decoded.Signature = append([]byte(nil), br.next(n)...)
+	if br.err != nil {
+		return br.err
+	}
+	if len(br.data) != 0 {
+		return errors.New("the encoded signed root has trailing bytes")
+	}
+	if err := decoded.Params.validate(); err != nil {
+		return err
+	}
+	*sr = decoded
+	return nil
+}
@@ -0,0 +1,97 @@
+package bloomtree
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func TestSignedRoot(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(500, seed, []byte{1}, []byte{2})
+	tree, err := NewBloomTree(dbf, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, key, err := ed25519.GenerateKey(bytes.NewReader(make([]byte, ed25519.SeedSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte{1}, ed25519.SeedSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewEd25519Signer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := tree.SignRoot(signer, 2, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.KeyID != Ed25519KeyID(pub) {
+		t.Fatalf("expected key ID %s, but got %s", Ed25519KeyID(pub), sr.KeyID)
+	}
+	root, err := sr.AuthenticatedRoot(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.GenerateCompactMultiProof([]byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := VerifyCompactMultiProof([]byte{1}, []byte(seed), proof, root, dbf, WithHashMode(HardenedHashMode)); err != nil || !verified {
+		t.Fatal("failed to verify proof against the authenticated root")
+	}
+	verifier, err := NewSignedRootVerifier(sr, pub, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := verifier.Verify([]byte{1}, proof); err != nil || !verified {
+		t.Fatal("failed to verify proof with the verifier of the signed root")
+	}
+
+	data, err := sr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SignedRoot
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignedRoot(&decoded, pub); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Timestamp.Equal(sr.Timestamp) || decoded.Epoch != 7 || decoded.Count != 2 || decoded.Params != tree.Params() {
+		t.Fatalf("expected decoded signed root %+v, but got %+v", sr, decoded)
+	}
+	if err := decoded.UnmarshalBinary(append(data, 0)); err == nil {
+		t.Fatal("expected error for trailing bytes")
+	}
+
+	var tests = []struct {
+		name   string
+		modify func(sr *SignedRoot)
+		pub    ed25519.PublicKey
+	}{
+		{name: "other key", modify: func(sr *SignedRoot) {}, pub: otherPub},
+		{name: "other key ID", modify: func(sr *SignedRoot) { sr.KeyID = Ed25519KeyID(otherPub) }, pub: otherPub},
+		{name: "modified root", modify: func(sr *SignedRoot) { sr.Root[0] ^= 1 }, pub: pub},
+		{name: "modified epoch", modify: func(sr *SignedRoot) { sr.Epoch++ }, pub: pub},
+		{name: "modified count", modify: func(sr *SignedRoot) { sr.Count++ }, pub: pub},
+		{name: "modified timestamp", modify: func(sr *SignedRoot) { sr.Timestamp = sr.Timestamp.Add(time.Second) }, pub: pub},
+		{name: "modified params", modify: func(sr *SignedRoot) { sr.Params.ChunkSize = 128 }, pub: pub},
+		{name: "invalid public key", modify: func(sr *SignedRoot) {}, pub: pub[:10]},
+	}
+	for _, test := range tests {
+		modified := *sr
+		test.modify(&modified)
+		if _, err := modified.AuthenticatedRoot(test.pub); err == nil {
+			t.Fatalf("expected error for %s", test.name)
+		}
+		if _, err := NewSignedRootVerifier(&modified, test.pub, []byte(seed)); err == nil {
+			t.Fatalf("expected error for %s", test.name)
+		}
+	}
+}
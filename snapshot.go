@@ -0,0 +1,131 @@
+package bloomtree
+
+import (
+	"errors"
+)
+
+// Snapshot is a read-only view of a bloom tree pinned to the root it had when the snapshot was taken.
+// It keeps generating proofs for that root while the tree is updated. A snapshot shares all unchanged words and
+// nodes with the tree; before the tree overwrites a word or node, the old value is copied into every snapshot
+// that is still in use. Snapshots must be released with Release once they are no longer needed, so the tree
+// stops copying values for them.
+type Snapshot struct {
+	tree *BloomTree
+	root Root
+	// shape is the shape of the tree, passed on from the tree.
+	shape treeShape
+	// words and nodes hold the values the tree overwrote since the snapshot was taken, nodes by their position
+	// in the tree shape.
+	words    map[int]uint64
+	nodes    map[int][32]byte
+	released bool
+}
+
+// Snapshot returns a read-only view of the current state of the tree.
+func (bt *BloomTree) Snapshot() *Snapshot {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	s := &Snapshot{
+		tree:  bt,
+		root:  bt.root,
+		shape: bt.shape,
+		words: make(map[int]uint64),
+		nodes: make(map[int][32]byte),
+	}
+	if bt.snapshots == nil {
+		bt.snapshots = make(map[*Snapshot]struct{})
+	}
+	bt.snapshots[s] = struct{}{}
+	return s
+}
+
+// setWord overwrites a word of the tree, keeping its old value for the snapshots in use.
+func (bt *BloomTree) setWord(i int, w uint64) {
+	if bt.words[i] == w {
+		return
+	}
+	for s := range bt.snapshots {
+		if _, ok := s.words[i]; !ok {
+			s.words[i] = bt.words[i]
+		}
+	}
+	bt.words[i] = w
+	if p, ok := bt.store.(persistentStore); ok {
+		p.putWord(i, w)
+	}
+}
+
+// setNode overwrites a node of the tree, keeping its old value for the snapshots in use.
+func (bt *BloomTree) setNode(level int, pos uint64, h [32]byte) error {
+	old, err := bt.node(level, pos)
+	if err != nil {
+		return err
+	}
+	if old == h {
+		return nil
+	}
+	i := bt.shape.index(level, pos)
+	for s := range bt.snapshots {
+		if _, ok := s.nodes[i]; !ok {
+			s.nodes[i] = old
+		}
+	}
+	return bt.store.Put(level, pos, h)
+}
+
+func (s *Snapshot) word(i int) uint64 {
+	if w, ok := s.words[i]; ok {
+		return w
+	}
+	return s.tree.words[i]
+}
+
+func (s *Snapshot) node(level int, pos uint64) ([32]byte, error) {
+	if h, ok := s.nodes[s.shape.index(level, pos)]; ok {
+		return h, nil
+	}
+	return s.tree.node(level, pos)
+}
+
+// Root returns the root of the tree when the snapshot was taken.
+func (s *Snapshot) Root() Root {
+	return s.root
+}
+
+// Params returns the geometry of the bloom tree.
+func (s *Snapshot) Params() Params {
+	return s.tree.Params()
+}
+
+// GenerateCompactMultiProof returns a compact multiproof to verify the presence, or absence of an element
+// against the root of the snapshot.
+func (s *Snapshot) GenerateCompactMultiProof(elem []byte) (*CompactMultiProof, error) {
+	s.tree.mu.RLock()
+	defer s.tree.mu.RUnlock()
+	if s.released {
+		return nil, errors.New("the snapshot was released")
+	}
+	return s.tree.compactMultiProof(s, elem)
+}
+
+// GenerateBatchProof returns a single compact multiproof for the presence, or absence of all given elements
+// against the root of the snapshot.
+func (s *Snapshot) GenerateBatchProof(elems [][]byte) (*BatchProof, error) {
+	s.tree.mu.RLock()
+	defer s.tree.mu.RUnlock()
+	if s.released {
+		return nil, errors.New("the snapshot was released")
+	}
+	return s.tree.batchProof(s, elems)
+}
+
+// Release frees the values the snapshot kept. Afterwards the snapshot can no longer generate proofs.
+// Releasing a snapshot more than once has no effect.
+func (s *Snapshot) Release() {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	delete(s.tree.snapshots, s)
+	s.released = true
+	s.words = nil
+	s.nodes = nil
+}
@@ -0,0 +1,138 @@
+package bloomtree
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	seed := []byte("secret seed")
+	f, err := NewStandardFilter(2000, 4, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		f.Add([]byte{byte(i)})
+	}
+	tree, err := NewBloomTree(f, WithHashMode(HardenedHashMode), WithLayout(BalancedLayout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := tree.Snapshot()
+	if first.Root() != tree.Root() {
+		t.Fatalf("expected snapshot root %s, but got %s", tree.Root(), first.Root())
+	}
+	for i := 20; i < 40; i++ {
+		if err := tree.Add([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := tree.Snapshot()
+	for i := 40; i < 60; i++ {
+		if err := tree.Add([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		name    string
+		root    Root
+		present int
+		proof   func(elem []byte) (*CompactMultiProof, error)
+	}{
+		{name: "first snapshot", root: first.Root(), present: 20, proof: first.GenerateCompactMultiProof},
+		{name: "second snapshot", root: second.Root(), present: 40, proof: second.GenerateCompactMultiProof},
+		{name: "tree", root: tree.Root(), present: 60, proof: tree.GenerateCompactMultiProof},
+	}
+	for _, test := range tests {
+		verifier, err := NewVerifier(test.root, tree.Params(), seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 60; i++ {
+			elem := []byte{byte(i)}
+			proof, err := test.proof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := verifier.Verify(elem, proof)
+			if err != nil {
+				t.Fatal(err)
+			} else if !verified {
+				t.Fatalf("failed to verify proof of element %d against the root of the %s", i, test.name)
+			}
+			if i < test.present && !CheckProofType(proof.ProofType) {
+				t.Fatalf("expected presence proof of element %d from the %s", i, test.name)
+			}
+		}
+	}
+
+	batch, err := first.GenerateBatchProof([][]byte{{1}, {30}, {50}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewVerifier(first.Root(), first.Params(), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := verifier.VerifyBatch([][]byte{{1}, {30}, {50}}, batch)
+	if err != nil {
+		t.Fatal(err)
+	} else if !verified {
+		t.Fatal("failed to verify batch proof of the first snapshot")
+	}
+
+	first.Release()
+	first.Release()
+	if _, err := first.GenerateCompactMultiProof([]byte{1}); err == nil {
+		t.Fatal("expected error for a released snapshot")
+	}
+	second.Release()
+	if len(tree.snapshots) != 0 {
+		t.Fatalf("expected no snapshots in use, but got %d", len(tree.snapshots))
+	}
+}
+
+func TestSnapshotConcurrentUpdates(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(500, seed, []byte{0})
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := tree.Snapshot()
+	defer snapshot.Release()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				elem := []byte{byte(i)}
+				proof, err := snapshot.GenerateCompactMultiProof(elem)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				verified, err := VerifyCompactMultiProof(elem, []byte(seed), proof, snapshot.Root(), dbf)
+				if err != nil || !verified {
+					t.Errorf("failed to verify proof of element %d against the snapshot root", i)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i < 100; i++ {
+		if err := tree.Add([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,156 @@
+package bloomtree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NodeStore holds the node hashes of a bloom tree by level and index. Level 0 holds the leaves, the last
+// level holds the root. A node store belongs to a single tree. The tree never calls Put or Flush concurrently
+// with any other call, but proofs, diffs and snapshots read nodes concurrently, so Get must be safe for
+// concurrent use.
+type NodeStore interface {
+	Get(level int, index uint64) ([32]byte, error)
+	Put(level int, index uint64, node [32]byte) error
+}
+
+// NodeFlusher is implemented by node stores that buffer the nodes written with Put. The tree calls Flush after
+// it was built and after every update, so all nodes of a new state are written together.
+type NodeFlusher interface {
+	Flush() error
+}
+
+// persistentStore is implemented by node stores that persist a whole tree, so it can be reopened with
+// OpenBloomTree. The tree writes its parameters, bloom filter words and nodes with putTree after it was built.
+// Words changed by updates are written with putWord, together with the nodes on the next flush.
+type persistentStore interface {
+	NodeFlusher
+	putTree(params Params, ref [32]byte, words []uint64, shape treeShape, nodes [][32]byte) error
+	putWord(i int, w uint64)
+	loadTree() (Params, [32]byte, []uint64, error)
+}
+
+// nodeAllocator is implemented by node stores that keep the nodes of a tree in a single slice, such as the
+// in-memory store and MmapStore. They also hold the bloom filter words of the tree, and the tree is built
+// directly into their slices.
+type nodeAllocator interface {
+	allocate(shape treeShape, numWords int) ([]uint64, [][32]byte, error)
+}
+
+// bitsHolder is implemented by node stores that also hold the bits of the bloom filter, such as MmapStore. After the
+// tree is allocated, the store moves the bits of the bloom filter into its own memory.
+type bitsHolder interface {
+	holdBits(b BloomFilter) error
+}
+
+// memoryStore is the default node store, it keeps all nodes in memory.
+type memoryStore struct {
+	shape treeShape
+	nodes [][32]byte
+}
+
+// newMemoryStore returns an in-memory node store. The nodes are allocated when the tree is built.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{}
+}
+
+func (s *memoryStore) allocate(shape treeShape, numWords int) ([]uint64, [][32]byte, error) {
+	s.shape = shape
+	s.nodes = make([][32]byte, shape.len())
+	return make([]uint64, numWords), s.nodes, nil
+}
+
+func (s *memoryStore) Get(level int, index uint64) ([32]byte, error) {
+	if err := checkNodePosition(s.shape, level, index); err != nil {
+		return [32]byte{}, err
+	}
+	return s.nodes[s.shape.index(level, index)], nil
+}
+
+func (s *memoryStore) Put(level int, index uint64, node [32]byte) error {
+	if err := checkNodePosition(s.shape, level, index); err != nil {
+		return err
+	}
+	s.nodes[s.shape.index(level, index)] = node
+	return nil
+}
+
+// checkNodePosition returns an error if the tree has no node at the given level and index.
+func checkNodePosition(shape treeShape, level int, index uint64) error {
+	if level < 0 || level >= len(shape.sizes) || index >= uint64(shape.sizes[level]) {
+		return fmt.Errorf("the tree has no node at level %d and index %d", level, index)
+	}
+	return nil
+}
+
+// allocateTree returns the words and nodes a tree of the given shape is built into, and whether they belong to the
+// store. Otherwise the nodes must be written to the store with putNodes after the build.
+func allocateTree(s NodeStore, shape treeShape, numWords int) ([]uint64, [][32]byte, bool, error) {
+	if a, ok := s.(nodeAllocator); ok {
+		words, nodes, err := a.allocate(shape, numWords)
+		return words, nodes, true, err
+	}
+	return make([]uint64, numWords), make([][32]byte, shape.len()), false, nil
+}
+
+// putNodes writes the nodes of a tree of the given shape to the store.
+func putNodes(s NodeStore, shape treeShape, nodes [][32]byte) error {
+	for level, size := range shape.sizes {
+		for pos := 0; pos < size; pos++ {
+			if err := s.Put(level, uint64(pos), nodes[shape.index(level, uint64(pos))]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flushStore flushes the nodes buffered by the store, if it buffers them.
+func flushStore(s NodeStore) error {
+	if f, ok := s.(NodeFlusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// OpenBloomTree reopens the tree held by a node store that persists trees, such as BoltStore, without rebuilding
+// the nodes. The bloom filter must have the same size, hash functions and seed as the bloom filter of the stored
+// tree. Its bits are replaced by the stored bits if it has a SetBitSet method, otherwise they must already equal
+// the stored bits.
+func OpenBloomTree(b BloomFilter, s NodeStore) (*BloomTree, error) {
+	p, ok := s.(persistentStore)
+	if !ok {
+		return nil, errors.New("the node store does not persist trees")
+	}
+	params, ref, words, err := p.loadTree()
+	if err != nil {
+		return nil, err
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if len(words) != numWords(params.M) {
+		return nil, errors.New("the number of words does not match the bloom filter")
+	}
+	if err := restoreBloomFilter(b, params, ref, words); err != nil {
+		return nil, err
+	}
+	bt := &BloomTree{
+		bf:        b,
+		words:     words,
+		m:         params.M,
+		k:         params.K,
+		store:     s,
+		chunkSize: params.ChunkSize,
+		hasher:    params.Hasher,
+		mode:      params.HashMode,
+		layout:    params.Layout,
+		shape:     newTreeShape(numLeafs(len(words), params.ChunkSize), params.Layout),
+	}
+	root, err := bt.node(bt.shape.height(), 0)
+	if err != nil {
+		return nil, err
+	}
+	bt.root = root
+	return bt, nil
+}
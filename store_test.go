@@ -0,0 +1,167 @@
+package bloomtree
+
+import (
+	"sync"
+	"testing"
+)
+
+// nodesOf returns the nodes of the tree in the order of its shape.
+func nodesOf(bt *BloomTree) [][32]byte {
+	shape := bt.shape
+	nodes := make([][32]byte, 0, shape.len())
+	for level, size := range shape.sizes {
+		for pos := 0; pos < size; pos++ {
+			node, _ := bt.node(level, uint64(pos))
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// mapStore is a node store without nodeAllocator, so trees are built next to it and written with Put.
+type mapStore map[[2]uint64][32]byte
+
+func (s mapStore) Get(level int, index uint64) ([32]byte, error) {
+	return s[[2]uint64{uint64(level), index}], nil
+}
+
+func (s mapStore) Put(level int, index uint64, node [32]byte) error {
+	s[[2]uint64{uint64(level), index}] = node
+	return nil
+}
+
+// countingStore is a node store that counts the reads of every node, so its Get mutates state.
+type countingStore struct {
+	mu    sync.Mutex
+	nodes mapStore
+	reads map[[2]uint64]int
+}
+
+func (s *countingStore) Get(level int, index uint64) ([32]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads[[2]uint64{uint64(level), index}]++
+	return s.nodes.Get(level, index)
+}
+
+func (s *countingStore) Put(level int, index uint64, node [32]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes.Put(level, index, node)
+}
+
+func TestNodeStoreConcurrentGet(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(1000, seed, [][]byte{{1}, {2}, {3}, {4}, {5}}...)
+	store := &countingStore{nodes: make(mapStore), reads: make(map[[2]uint64]int)}
+	tree, err := NewBloomTree(dbf, WithNodeStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := tree.Snapshot()
+	defer snapshot.Release()
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(elem []byte) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := tree.GenerateCompactMultiProof(elem); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}([]byte{byte(i)})
+		go func(elem []byte) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := snapshot.GenerateCompactMultiProof(elem); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}([]byte{byte(i)})
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(store.reads) == 0 {
+		t.Fatal("expected the proofs to read nodes from the store")
+	}
+}
+
+func TestNodeStore(t *testing.T) {
+	seed := "secret seed"
+	var tests = []struct {
+		name   string
+		layout Layout
+	}{
+		{name: "padded layout", layout: PaddedLayout},
+		{name: "balanced layout", layout: BalancedLayout},
+	}
+	for _, test := range tests {
+		dbf := generateDBF(1500, seed, []byte{1}, []byte{2})
+		memory, err := NewBloomTree(dbf, WithLayout(test.layout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := make(mapStore)
+		tree, err := NewBloomTree(dbf, WithLayout(test.layout), WithNodeStore(store))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(store) != tree.shape.len() {
+			t.Fatalf("expected %d nodes in the store for the %s, but got %d", tree.shape.len(), test.name, len(store))
+		}
+		if tree.Root() != memory.Root() {
+			t.Fatalf("expected root %s for the %s, but got %s", memory.Root(), test.name, tree.Root())
+		}
+		for _, bt := range []*BloomTree{memory, tree} {
+			if err := bt.Add([]byte{3}, []byte{4}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tree.Root() != memory.Root() {
+			t.Fatalf("expected root %s after adding elements for the %s, but got %s", memory.Root(), test.name, tree.Root())
+		}
+		for _, elem := range [][]byte{{1}, {3}, {5}} {
+			proof, err := tree.GenerateCompactMultiProof(elem)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verified, err := VerifyCompactMultiProof(elem, []byte(seed), proof, tree.Root(), dbf, WithLayout(test.layout))
+			if err != nil {
+				t.Fatal(err)
+			} else if !verified {
+				t.Fatalf("failed to verify proof of element %v for the %s", elem, test.name)
+			}
+		}
+	}
+}
+
+func TestMemoryStoreInvalidPosition(t *testing.T) {
+	tree, err := NewBloomTree(generateDBF(200, "secret seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	shape := tree.shape
+	var tests = []struct {
+		level int
+		index uint64
+	}{
+		{level: -1, index: 0},
+		{level: 0, index: uint64(shape.sizes[0])},
+		{level: shape.height() + 1, index: 0},
+	}
+	for _, test := range tests {
+		if _, err := tree.store.Get(test.level, test.index); err == nil {
+			t.Fatalf("expected error for node %d at level %d", test.index, test.level)
+		}
+		if err := tree.store.Put(test.level, test.index, [32]byte{}); err == nil {
+			t.Fatalf("expected error for node %d at level %d", test.index, test.level)
+		}
+	}
+}
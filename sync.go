@@ -0,0 +1,316 @@
+package bloomtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// syncBatchSize is the largest number of nodes or chunks requested from a replica at once.
+const syncBatchSize = 1024
+
+// SyncRequestType is the kind of a sync request.
+type SyncRequestType uint8
+
+const (
+	// SyncParamsRequest asks for the parameters of the tree.
+	SyncParamsRequest SyncRequestType = iota
+	// SyncNodesRequest asks for the nodes at the given indices of a level.
+	SyncNodesRequest
+	// SyncChunksRequest asks for the bloom filter words of the chunks at the given indices.
+	SyncChunksRequest
+)
+
+// SyncRequest is a request of the anti-entropy protocol, sent by a replica that pulls from another replica.
+type SyncRequest struct {
+	Type    SyncRequestType `cbor:"type"`
+	Level   int             `cbor:"level,omitempty"`
+	Indices []uint64        `cbor:"indices,omitempty"`
+}
+
+// SyncResponse is the answer to a SyncRequest. Error is set if the request failed.
+type SyncResponse struct {
+	Params *Params    `cbor:"params,omitempty"`
+	Nodes  [][32]byte `cbor:"nodes,omitempty"`
+	Chunks [][]uint64 `cbor:"chunks,omitempty"`
+	Error  string     `cbor:"error,omitempty"`
+}
+
+// Transport sends sync requests to a replica and returns its responses.
+type Transport interface {
+	RoundTrip(req *SyncRequest) (*SyncResponse, error)
+}
+
+// SyncServer answers the sync requests of other replicas from a tree.
+type SyncServer struct {
+	tree *BloomTree
+}
+
+// NewSyncServer creates a server for the tree.
+func NewSyncServer(bt *BloomTree) *SyncServer {
+	return &SyncServer{tree: bt}
+}
+
+// Handle answers a sync request.
+func (s *SyncServer) Handle(req *SyncRequest) *SyncResponse {
+	if len(req.Indices) > syncBatchSize {
+		return &SyncResponse{Error: fmt.Sprintf("a request must not have more than %d indices", syncBatchSize)}
+	}
+	switch req.Type {
+	case SyncParamsRequest:
+		params := s.tree.Params()
+		return &SyncResponse{Params: &params}
+	case SyncNodesRequest:
+		nodes, err := s.tree.Nodes(req.Level, req.Indices)
+		if err != nil {
+			return &SyncResponse{Error: err.Error()}
+		}
+		return &SyncResponse{Nodes: nodes}
+	case SyncChunksRequest:
+		chunks, err := s.tree.chunks(req.Indices)
+		if err != nil {
+			return &SyncResponse{Error: err.Error()}
+		}
+		return &SyncResponse{Chunks: chunks}
+	}
+	return &SyncResponse{Error: fmt.Sprintf("unknown sync request type %d", req.Type)}
+}
+
+// Serve accepts connections on the listener and answers the requests sent by TCPTransport on each of them.
+// It returns when the listener is closed.
+func (s *SyncServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *SyncServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	dec := cborDecMode.NewDecoder(conn)
+	enc := cborEncMode.NewEncoder(conn)
+	for {
+		var req SyncRequest
+		if err := dec.Decode(&req); err != nil {
+			if err != io.EOF {
+				enc.Encode(&SyncResponse{Error: err.Error()})
+			}
+			return
+		}
+		if err := enc.Encode(s.Handle(&req)); err != nil {
+			return
+		}
+	}
+}
+
+// chunks returns the words of the chunks at the given indices.
+func (bt *BloomTree) chunks(indices []uint64) ([][]uint64, error) {
+	bt.mu.RLock()
+	defer bt.mu.RUnlock()
+	leafCount := uint64(numLeafs(len(bt.words), bt.chunkSize))
+	chunks := make([][]uint64, len(indices))
+	for i, index := range indices {
+		if index >= leafCount {
+			return nil, errors.New("the chunk index exceeds the tree")
+		}
+		chunks[i] = chunkWords(bt, len(bt.words), index, bt.chunkSize)
+	}
+	return chunks, nil
+}
+
+// LocalTransport sends sync requests to a server in the same process.
+type LocalTransport struct {
+	server *SyncServer
+}
+
+// NewLocalTransport creates a transport to the server.
+func NewLocalTransport(s *SyncServer) *LocalTransport {
+	return &LocalTransport{server: s}
+}
+
+// RoundTrip answers the request with the server.
+func (t *LocalTransport) RoundTrip(req *SyncRequest) (*SyncResponse, error) {
+	return t.server.Handle(req), nil
+}
+
+// TCPTransport sends sync requests to a SyncServer over a TCP connection. Requests and responses are CBOR
+// encoded. A TCPTransport is safe for concurrent use, requests are sent one at a time.
+type TCPTransport struct {
+	mu   sync.Mutex
+	conn net.Conn
+	dec  *cbor.Decoder
+	enc  *cbor.Encoder
+}
+
+// DialTCPTransport connects to the SyncServer listening at the address.
+func DialTCPTransport(addr string) (*TCPTransport, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCPTransport{conn: conn, dec: cborDecMode.NewDecoder(conn), enc: cborEncMode.NewEncoder(conn)}, nil
+}
+
+// RoundTrip sends the request and waits for the response.
+func (t *TCPTransport) RoundTrip(req *SyncRequest) (*SyncResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.enc.Encode(req); err != nil {
+		return nil, err
+	}
+	var resp SyncResponse
+	if err := t.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Close closes the connection.
+func (t *TCPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// remoteTree is the NodeSource of a replica behind a transport.
+type remoteTree struct {
+	transport Transport
+	params    Params
+}
+
+func roundTrip(t Transport, req *SyncRequest) (*SyncResponse, error) {
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("the replica failed the request: %s", resp.Error)
+	}
+	return resp, nil
+}
+
+func (r *remoteTree) Params() Params {
+	return r.params
+}
+
+// Nodes requests the nodes of a level in batches of at most syncBatchSize.
+func (r *remoteTree) Nodes(level int, indices []uint64) ([][32]byte, error) {
+	var nodes [][32]byte
+	for start := 0; start < len(indices); start += syncBatchSize {
+		end := start + syncBatchSize
+		if end > len(indices) {
+			end = len(indices)
+		}
+		resp, err := roundTrip(r.transport, &SyncRequest{Type: SyncNodesRequest, Level: level, Indices: indices[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Nodes) != end-start {
+			return nil, errors.New("the replica returned a wrong number of nodes")
+		}
+		nodes = append(nodes, resp.Nodes...)
+	}
+	return nodes, nil
+}
+
+// chunks requests the words of the chunks in batches of at most syncBatchSize.
+func (r *remoteTree) chunks(indices []uint64) ([][]uint64, error) {
+	var chunks [][]uint64
+	for start := 0; start < len(indices); start += syncBatchSize {
+		end := start + syncBatchSize
+		if end > len(indices) {
+			end = len(indices)
+		}
+		resp, err := roundTrip(r.transport, &SyncRequest{Type: SyncChunksRequest, Indices: indices[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Chunks) != end-start {
+			return nil, errors.New("the replica returned a wrong number of chunks")
+		}
+		chunks = append(chunks, resp.Chunks...)
+	}
+	return chunks, nil
+}
+
+// Sync pulls the state of the replica behind the transport into the tree and returns the indices of the chunks
+// it repaired. It compares the trees level by level with Diff, requests the words of the differing chunks and
+// checks them against the leaves of the replica. Before the tree is changed, Sync checks that the repaired chunks
+// together with the unchanged nodes of the tree hash to the root of the replica, so a successful sync ends with
+// the root the replica had when the sync started. The chunks are then written into the bits of the bloom filter,
+// which must have a SetBitSet method, so its bits are not derived from other state such as the counters of a
+// CountingFilter. If either tree changes during the sync, Sync returns an error, leaves the tree
+// unchanged and can be retried.
+func (bt *BloomTree) Sync(t Transport) ([]uint64, error) {
+	if !canSetBitSet(bt.bf) {
+		return nil, errors.New("the bloom filter does not support replacing its bits")
+	}
+	resp, err := roundTrip(t, &SyncRequest{Type: SyncParamsRequest})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Params == nil {
+		return nil, errors.New("the replica returned no parameters")
+	}
+	remote := &remoteTree{transport: t, params: *resp.Params}
+	indices, leaves, root, err := bt.diff(remote)
+	if err != nil || len(indices) == 0 {
+		return nil, err
+	}
+	chunks, err := remote.chunks(indices)
+	if err != nil {
+		return nil, err
+	}
+	th := bt.treeHasher()
+	step := uint64(bt.chunkSize / 64)
+	for i, chunk := range chunks {
+		length := uint64(len(bt.words)) - indices[i]*step
+		if length > step {
+			length = step
+		}
+		if uint64(len(chunk)) != length || th.leaf(indices[i], chunk...) != leaves[i] {
+			return nil, errors.New("the chunk does not match the leaf of the replica")
+		}
+	}
+
+	// the hashes of the unchanged subtrees next to the repaired chunks must reconstruct the root of the replica
+	bt.mu.RLock()
+	base := bt.root
+	proof, err := bt.generateProof(bt, indices)
+	bt.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	verified, err := verifyChunks(indices, chunks, proof, root, bt.Params())
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		return nil, errors.New("the repaired chunks do not match the root of the replica")
+	}
+
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if bt.root != base {
+		return nil, errors.New("the tree changed during the sync")
+	}
+	// the chunks are written in place, so bits backed by the store, as with MmapStore, stay there
+	words := bt.bf.BitArray().Bytes()
+	if len(words) != len(bt.words) {
+		return nil, errors.New("the size of the bloom filter changed since the tree was built")
+	}
+	changed := make([]uint, len(indices))
+	for i, index := range indices {
+		copy(words[index*step:], chunks[i])
+		changed[i] = uint(index) * uint(bt.chunkSize)
+	}
+	if err := bt.refresh(changed); err != nil {
+		return nil, err
+	}
+	return indices, nil
+}
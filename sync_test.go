@@ -0,0 +1,215 @@
+package bloomtree
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	seed := []byte("secret seed")
+	var tests = []struct {
+		name      string
+		opts      []Option
+		transport func(s *SyncServer) (Transport, func())
+	}{
+		{
+			name: "in-process transport",
+			opts: []Option{WithChunkSize(128)},
+			transport: func(s *SyncServer) (Transport, func()) {
+				return NewLocalTransport(s), func() {}
+			},
+		},
+		{
+			name: "TCP transport",
+			opts: []Option{WithLayout(BalancedLayout), WithHashMode(HardenedHashMode)},
+			transport: func(s *SyncServer) (Transport, func()) {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				go s.Serve(l)
+				tr, err := DialTCPTransport(l.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return tr, func() {
+					tr.Close()
+					l.Close()
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		a, err := NewStandardFilter(20000, 4, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewStandardFilter(20000, 4, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			a.Add([]byte{byte(i)})
+			b.Add([]byte{byte(i)})
+		}
+		local, err := NewBloomTree(a, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remote, err := NewBloomTree(b, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := local.Add([]byte("only local")); err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Add([]byte("only remote"), []byte("also remote")); err != nil {
+			t.Fatal(err)
+		}
+		want, err := local.Diff(remote)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(want) == 0 {
+			t.Fatalf("expected the trees of the %s to differ", test.name)
+		}
+		transport, closeTransport := test.transport(NewSyncServer(remote))
+		repaired, err := local.Sync(transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(repaired) != len(want) {
+			t.Fatalf("expected %d repaired chunks with the %s, but got %d", len(want), test.name, len(repaired))
+		}
+		if local.Root() != remote.Root() {
+			t.Fatalf("expected root %s after syncing with the %s, but got %s", remote.Root(), test.name, local.Root())
+		}
+		if !a.BitArray().Equal(b.BitArray()) {
+			t.Fatalf("expected equal bloom filters after syncing with the %s", test.name)
+		}
+		rebuilt, err := NewBloomTree(a, test.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rebuilt.Root() != local.Root() {
+			t.Fatalf("expected the synced tree to match a rebuilt tree with the %s", test.name)
+		}
+		if repaired, err := local.Sync(transport); err != nil || len(repaired) != 0 {
+			t.Fatalf("expected no repaired chunks for synced trees with the %s, but got %v, %v", test.name, repaired, err)
+		}
+		if _, err := roundTrip(transport, &SyncRequest{Type: SyncChunksRequest, Indices: []uint64{1 << 40}}); err == nil {
+			t.Fatalf("expected error for a chunk outside the tree with the %s", test.name)
+		}
+		closeTransport()
+	}
+}
+
+// hookTransport calls a function before it forwards a request.
+type hookTransport struct {
+	Transport
+	hook func(req *SyncRequest, resp *SyncResponse)
+}
+
+func (t *hookTransport) RoundTrip(req *SyncRequest) (*SyncResponse, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil {
+		t.hook(req, resp)
+	}
+	return resp, err
+}
+
+func TestSyncRejected(t *testing.T) {
+	seed := "secret seed"
+	local, err := NewBloomTree(generateDBF(500, seed, []byte{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewBloomTree(generateDBF(500, seed, []byte{1}, []byte{2}, []byte{3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewLocalTransport(NewSyncServer(remote))
+	height := local.shape.height()
+
+	var tests = []struct {
+		name string
+		hook func(req *SyncRequest, resp *SyncResponse)
+		// unchanged is whether the sync must leave the tree as it was
+		unchanged bool
+	}{
+		{
+			name:      "a forged root",
+			unchanged: true,
+			hook: func(req *SyncRequest, resp *SyncResponse) {
+				if req.Type == SyncNodesRequest && req.Level == height {
+					resp.Nodes[0][0] ^= 1
+				}
+			},
+		},
+		{
+			name: "a local update during the sync",
+			hook: func(req *SyncRequest, resp *SyncResponse) {
+				if req.Type == SyncChunksRequest {
+					if err := local.Add([]byte{4}); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		root := local.Root()
+		bits := local.GetBloomFilter().BitArray().Clone()
+		if _, err := local.Sync(&hookTransport{Transport: server, hook: test.hook}); err == nil {
+			t.Fatalf("expected error for %s", test.name)
+		}
+		if test.unchanged && (local.Root() != root || !local.GetBloomFilter().BitArray().Equal(bits)) {
+			t.Fatalf("expected the tree to be unchanged after %s", test.name)
+		}
+	}
+	// the element added during the failed sync is kept
+	if _, present := local.GetBloomFilter().Proof([]byte{4}); !present {
+		t.Fatal("expected the element added during the sync to be kept")
+	}
+	rebuilt, err := NewBloomTree(local.GetBloomFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Root() != local.Root() {
+		t.Fatal("expected the tree to match its bloom filter after the failed syncs")
+	}
+
+	// a tree syncing with itself must not lock itself
+	if repaired, err := local.Sync(NewLocalTransport(NewSyncServer(local))); err != nil || len(repaired) != 0 {
+		t.Fatalf("expected no repaired chunks for a tree syncing with itself, but got %v, %v", repaired, err)
+	}
+}
+
+func TestSyncInvalid(t *testing.T) {
+	seed := []byte("secret seed")
+	remote, err := NewBloomTree(generateDBF(600, string(seed), []byte{1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := NewLocalTransport(NewSyncServer(remote))
+	local, err := NewBloomTree(generateDBF(500, string(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Sync(transport); err == nil {
+		t.Fatal("expected error for trees with different parameters")
+	}
+	counting, err := NewCountingFilter(1000, 3, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewBloomTree(counting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.Sync(transport); err == nil {
+		t.Fatal("expected error for a bloom filter without SetBitSet")
+	}
+	if _, err := roundTrip(transport, &SyncRequest{Type: SyncRequestType(9)}); err == nil {
+		t.Fatal("expected error for an unknown request type")
+	}
+}
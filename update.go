@@ -0,0 +1,143 @@
+package bloomtree
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// adder is implemented by bloom filters that can insert elements, such as the DBF package.
+type adder interface {
+	Add([]byte)
+}
+
+// remover is implemented by bloom filters that can delete elements, such as CountingFilter.
+type remover interface {
+	Remove([]byte) error
+}
+
+// Add inserts the elements into the bloom filter of the tree and updates the tree. Only the chunks the elements
+// map to and their ancestors are rehashed. The bloom filter must have an Add method.
+func (bt *BloomTree) Add(elems ...[]byte) error {
+	a, ok := bt.bf.(adder)
+	if !ok {
+		return errors.New("the bloom filter does not support adding elements")
+	}
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	var changed []uint
+	for _, elem := range elems {
+		a.Add(elem)
+		changed = append(changed, bt.bf.GetElementIndices(elem)...)
+	}
+	return bt.refresh(changed)
+}
+
+// Remove deletes the elements from the bloom filter of the tree and updates the tree. Only the chunks the elements
+// map to and their ancestors are rehashed, so absence proofs reflect bits that flipped back to zero.
+// The bloom filter must have a Remove method. If an element cannot be removed, the elements removed before it
+// stay removed and the tree is updated for them.
+func (bt *BloomTree) Remove(elems ...[]byte) error {
+	r, ok := bt.bf.(remover)
+	if !ok {
+		return errors.New("the bloom filter does not support removing elements")
+	}
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	var changed []uint
+	for _, elem := range elems {
+		if err := r.Remove(elem); err != nil {
+			if rerr := bt.refresh(changed); rerr != nil {
+				return rerr
+			}
+			return err
+		}
+		changed = append(changed, bt.bf.GetElementIndices(elem)...)
+	}
+	return bt.refresh(changed)
+}
+
+// Refresh updates the tree after the given bits of its bloom filter changed, for example because elements were
+// added to the bloom filter directly. Only the chunks containing the bits and their ancestors are rehashed.
+func (bt *BloomTree) Refresh(changedBits []uint) error {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	return bt.refresh(changedBits)
+}
+
+func (bt *BloomTree) refresh(changedBits []uint) error {
+	if len(changedBits) == 0 {
+		return nil
+	}
+	bf := bt.bf.BitArray()
+	bfAsInt := bf.Bytes()
+	if bf.Len() != bt.m || len(bfAsInt) != len(bt.words) {
+		return errors.New("the size of the bloom filter changed since the tree was built")
+	}
+	dirty := make([]uint64, 0, len(changedBits))
+	for _, bit := range changedBits {
+		if bit >= bt.m {
+			return fmt.Errorf("bit %d exceeds the bloom filter of %d bits", bit, bt.m)
+		}
+		dirty = append(dirty, uint64(bit)/uint64(bt.chunkSize))
+	}
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i] < dirty[j] })
+	dirty = uniqueChunkIndices(dirty)
+
+	th := bt.treeHasher()
+	step := uint64(bt.chunkSize / 64)
+	for _, index := range dirty {
+		start := index * step
+		end := start + step
+		if end > uint64(len(bt.words)) {
+			end = uint64(len(bt.words))
+		}
+		for i := start; i < end; i++ {
+			bt.setWord(int(i), bfAsInt[i])
+		}
+		if err := bt.setNode(0, index, th.leaf(index, bt.words[start:end]...)); err != nil {
+			return err
+		}
+	}
+	if err := bt.updateAncestors(dirty); err != nil {
+		return err
+	}
+	return flushStore(bt.store)
+}
+
+// updateAncestors rehashes the ancestors of the given sorted leaves, level by level up to the root.
+func (bt *BloomTree) updateAncestors(dirty []uint64) error {
+	th := bt.treeHasher()
+	shape := bt.shape
+	for level := 0; level < shape.height(); level++ {
+		var parents []uint64
+		for _, node := range dirty {
+			parent := node / 2
+			if len(parents) > 0 && parents[len(parents)-1] == parent {
+				continue
+			}
+			parents = append(parents, parent)
+			h, err := bt.node(level, 2*parent)
+			if err != nil {
+				return err
+			}
+			if 2*parent+1 < uint64(shape.sizes[level]) {
+				right, err := bt.node(level, 2*parent+1)
+				if err != nil {
+					return err
+				}
+				h = th.child(h, right)
+			}
+			if err := bt.setNode(level+1, parent, h); err != nil {
+				return err
+			}
+		}
+		dirty = parents
+	}
+	root, err := bt.node(shape.height(), 0)
+	if err != nil {
+		return err
+	}
+	bt.root = root
+	return nil
+}
@@ -0,0 +1,159 @@
+package bloomtree
+
+import (
+	"sync"
+	"testing"
+)
+
+// noAddBF hides the Add method of a bloom filter.
+type noAddBF struct {
+	BloomFilter
+}
+
+func TestBloomTreeAdd(t *testing.T) {
+	seed := "secret seed"
+	var tests = []struct {
+		chunkSize int
+		mode      HashMode
+	}{
+		{chunkSize: 64, mode: LegacyHashMode},
+		{chunkSize: 512, mode: LegacyHashMode},
+		{chunkSize: 64, mode: HardenedHashMode},
+		{chunkSize: 128, mode: HardenedHashMode},
+	}
+
+	for _, test := range tests {
+		dbf := generateDBF(200, seed, []byte{1}, []byte{2})
+		tree, err := NewBloomTree(dbf, WithChunkSize(test.chunkSize), WithHashMode(test.mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 3; i < 60; i += 7 {
+			if err := tree.Add([]byte{byte(i)}, []byte{byte(i + 1)}); err != nil {
+				t.Fatal(err)
+			}
+			full, err := NewBloomTree(dbf, WithChunkSize(test.chunkSize), WithHashMode(test.mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tree.Root() != full.Root() {
+				t.Fatalf("expected root %s after adding element %d with chunk size %d, but got %s", full.Root(), i, test.chunkSize, tree.Root())
+			}
+		}
+		proof, err := tree.GenerateCompactMultiProof([]byte{3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		verified, err := VerifyCompactMultiProof([]byte{3}, []byte(seed), proof, tree.Root(), dbf, WithChunkSize(test.chunkSize), WithHashMode(test.mode))
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified || !CheckProofType(proof.ProofType) {
+			t.Fatal("failed to verify presence proof of an added element")
+		}
+	}
+}
+
+func TestBloomTreeRefresh(t *testing.T) {
+	dbf := generateDBF(200, "secret seed", []byte{1}, []byte{2})
+	tree, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dbf.BitArray().Len()
+	bits := []uint{0, 5, 64, m - 1}
+	indices := make([]int, len(bits))
+	for i, bit := range bits {
+		indices[i] = int(bit)
+	}
+	dbf.SetIndices(indices)
+	if err := tree.Refresh(bits); err != nil {
+		t.Fatal(err)
+	}
+	full, err := NewBloomTree(dbf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Root() != full.Root() {
+		t.Fatalf("expected root %s after refresh, but got %s", full.Root(), tree.Root())
+	}
+
+	if err := tree.Refresh([]uint{m}); err == nil {
+		t.Fatal("expected error for a bit outside of the bloom filter")
+	}
+	other, err := NewBloomTree(noAddBF{dbf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Add([]byte{3}); err == nil {
+		t.Fatal("expected error for a bloom filter without an Add method")
+	}
+}
+
+func TestBloomTreeConcurrentUpdates(t *testing.T) {
+	seed := "secret seed"
+	dbf := generateDBF(500, seed, []byte{0})
+	tree, err := NewBloomTree(dbf, WithHashMode(HardenedHashMode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type result struct {
+		elem  []byte
+		proof *CompactMultiProof
+	}
+	roots := map[Root]bool{tree.Root(): true}
+	done := make(chan struct{})
+	results := make(chan result, 1000)
+
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				elem := []byte{byte(i + r)}
+				proof, err := tree.GenerateCompactMultiProof(elem)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := tree.GenerateBatchProof([][]byte{elem, {byte(i)}}); err != nil {
+					t.Error(err)
+					return
+				}
+				tree.Root()
+				select {
+				case results <- result{elem: elem, proof: proof}:
+				default:
+				}
+			}
+		}(r)
+	}
+	for i := 1; i < 100; i++ {
+		if err := tree.Add([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+		roots[tree.Root()] = true
+	}
+	close(done)
+	wg.Wait()
+	close(results)
+
+	for res := range results {
+		verified := false
+		for root := range roots {
+			ok, err := VerifyCompactMultiProof(res.elem, []byte(seed), res.proof, root, dbf, WithHashMode(HardenedHashMode))
+			if err == nil && ok {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			t.Fatalf("expected proof of element %v to match one of the roots of the tree", res.elem)
+		}
+	}
+}
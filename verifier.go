@@ -0,0 +1,102 @@
+package bloomtree
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+)
+
+// Verifier checks compact multiproofs against a bloom tree root. In contrast to VerifyCompactMultiProof,
+// it does not need the bloom filter: the element indices are derived from the seed and the tree parameters,
+// and the element bits are read from the chunks carried by the proof.
+type Verifier struct {
+	root   [32]byte
+	params Params
+	seed   []byte
+}
+
+// NewVerifier creates a verifier for the bloom tree with the given root and parameters.
+// The seed is the seed value of the bloom filter the tree was built from.
+func NewVerifier(root [32]byte, params Params, seed []byte) (*Verifier, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	s := make([]byte, len(seed))
+	copy(s, seed)
+	return &Verifier{
+		root:   root,
+		params: params,
+		seed:   s,
+	}, nil
+}
+
+// Root returns the bloom tree root the verifier checks proofs against.
+func (v *Verifier) Root() Root {
+	return v.root
+}
+
+// Params returns the bloom tree parameters of the verifier.
+func (v *Verifier) Params() Params {
+	return v.params
+}
+
+// Verify returns whether the multi proof provided is true or false.
+// The proof type can be absence or presence.
+func (v *Verifier) Verify(element []byte, multiproof *CompactMultiProof) (bool, error) {
+	if multiproof == nil {
+		return false, errors.New("there was no proof provided")
+	}
+	if err := v.params.checkProof(multiproof.ChunkSize, multiproof.Hasher, multiproof.HashMode, multiproof.Layout); err != nil {
+		return false, err
+	}
+	elemIndices := mapElement(element, v.seed, v.params)
+	return verifyElementProof(elemIndices, multiproof, v.root, v.params)
+}
+
+// checkProof returns an error if the tree parameters recorded in a proof do not match p.
+func (p Params) checkProof(chunkSize int, hasher Hasher, mode HashMode, layout Layout) error {
+	if chunkSize != p.ChunkSize {
+		return errors.New("the chunk size of the proof does not match the tree")
+	}
+	if hasher != p.Hasher {
+		return errors.New("the hasher of the proof does not match the tree")
+	}
+	if mode != p.HashMode {
+		return errors.New("the hash mode of the proof does not match the tree")
+	}
+	if layout != p.Layout {
+		return errors.New("the layout of the proof does not match the tree")
+	}
+	return nil
+}
+
+// mapElement returns the bloom filter indices of an element with the index scheme of the tree.
+func mapElement(element, seed []byte, params Params) []uint {
+	switch params.IndexScheme {
+	case DoubleHashingIndexScheme:
+		return doubleHashing(element, seed, params.M, params.K)
+	case BlockedIndexScheme:
+		return blockedHashing(element, seed, params.M, params.K, uint(params.ChunkSize))
+	default:
+		return dbfIndices(element, seed, params.M, params.K)
+	}
+}
+
+// dbfIndices returns the bloom filter indices of an element. The i-th index is the first 8 bytes (big endian)
+// of SHA512/256(seed || i) XOR SHA512/256(element), truncated to uint and taken modulo m. This matches the
+// MapElementToBF method of the DBF package, including on 32-bit platforms.
+func dbfIndices(element, seed []byte, m, k uint) []uint {
+	elemHash := sha512.Sum512_256(element)
+	data := make([]byte, len(seed)+1)
+	copy(data, seed)
+	indices := make([]uint, k)
+	for i := uint(0); i < k; i++ {
+		data[len(seed)] = byte(i)
+		seedHash := sha512.Sum512_256(data)
+		for j := range seedHash {
+			seedHash[j] ^= elemHash[j]
+		}
+		indices[i] = uint(binary.BigEndian.Uint64(seedHash[:])) % m
+	}
+	return indices
+}
@@ -0,0 +1,153 @@
+package bloomtree
+
+import (
+	"testing"
+)
+
+func TestMapElement(t *testing.T) {
+	seed := []byte("secret seed")
+	dbf := generateDBF(200, string(seed))
+	for _, elem := range [][]byte{{0}, {1}, []byte("Foo"), []byte("Bar")} {
+		expected := dbf.MapElementToBF(elem, seed)
+		indices := dbfIndices(elem, seed, dbf.BitArray().Len(), dbf.NumOfHashes())
+		if len(indices) != len(expected) {
+			t.Fatalf("expected %d indices, but got %d", len(expected), len(indices))
+		}
+		for i := range expected {
+			if indices[i] != expected[i] {
+				t.Fatalf("expected index %d, but got %d", expected[i], indices[i])
+			}
+		}
+	}
+}
+
+func TestVerifierPresenceAndAbsence(t *testing.T) {
+	var tests = []struct {
+		element  []byte
+		present  bool
+		elements [][]byte
+	}{
+		{
+			element:  []byte{1},
+			present:  true,
+			elements: [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		},
+		{
+			element:  []byte{9},
+			present:  false,
+			elements: [][]byte{{1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}},
+		},
+		{
+			element: []byte{17},
+			present: false,
+			elements: [][]byte{{0}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}, {10}, {11}, {12}, {13},
+				{14}, {15}, {16}},
+		},
+	}
+
+	for _, test := range tests {
+		seed := "secret seed"
+		dbf := generateDBF(200, seed, test.elements...)
+		tree, err := NewBloomTree(dbf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		multiproof, err := tree.GenerateCompactMultiProof(test.element)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if CheckProofType(multiproof.ProofType) != test.present {
+			t.Fatalf("expected presence %v for element %v", test.present, test.element)
+		}
+		verifier, err := NewVerifier(tree.Root(), tree.Params(), []byte(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verified, err := verifier.Verify(test.element, multiproof)
+		if err != nil {
+			t.Fatal(err)
+		} else if !verified {
+			t.Fatalf("failed to verify proof for element %v", test.element)
+		}
+	}
+}
+
+func TestVerifierRejectsWrongRootAndSeed(t *testing.T) {
+	seed := "secret seed"
+	element := []byte{1}
+	tree, err := NewBloomTree(generateDBF(200, seed, [][]byte{{1}, {2}, {3}}...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiproof, err := tree.GenerateCompactMultiProof(element)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := tree.Root()
+	root[0] ^= 1
+	verifier, err := NewVerifier(root, tree.Params(), []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := verifier.Verify(element, multiproof); err != nil {
+		t.Fatal(err)
+	} else if verified {
+		t.Fatal("expected proof to be rejected against a wrong root")
+	}
+
+	params := tree.Params()
+	params.ChunkSize = 128
+	verifier, err = NewVerifier(tree.Root(), params, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(element, multiproof); err == nil {
+		t.Fatal("expected proof to be rejected with a different chunk size")
+	}
+
+	params = tree.Params()
+	params.Hasher = Keccak256
+	verifier, err = NewVerifier(tree.Root(), params, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(element, multiproof); err == nil {
+		t.Fatal("expected proof to be rejected with a different hasher")
+	}
+
+	params = tree.Params()
+	params.HashMode = HardenedHashMode
+	verifier, err = NewVerifier(tree.Root(), params, []byte(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(element, multiproof); err == nil {
+		t.Fatal("expected legacy proof to be rejected by a hardened verifier")
+	}
+
+	verifier, err = NewVerifier(tree.Root(), tree.Params(), []byte("other seed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified, err := verifier.Verify(element, multiproof); err == nil && verified {
+		t.Fatal("expected proof to be rejected with a wrong seed")
+	}
+}
+
+func TestNewVerifierInvalidParams(t *testing.T) {
+	var tests = []Params{
+		{M: 0, K: 3, ChunkSize: 64},
+		{M: 200, K: 0, ChunkSize: 64},
+		{M: 200, K: uint(maxK), ChunkSize: 64},
+		{M: 200, K: 3, ChunkSize: 0},
+		{M: 200, K: 3, ChunkSize: 100},
+		{M: 200, K: 3, ChunkSize: 64, Hasher: Hasher(200)},
+		{M: 200, K: 3, ChunkSize: 64, HashMode: HashMode(7)},
+	}
+	for _, params := range tests {
+		if _, err := NewVerifier([32]byte{}, params, nil); err == nil {
+			t.Fatalf("expected error for parameters %+v", params)
+		}
+	}
+}